@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// colorScheme holds the ANSI SGR codes the built-in client colors its output with
+type colorScheme struct {
+	// statusOK, statusRedirect and statusError color the status line of 2xx, 3xx and 4xx/5xx responses
+	statusOK       string
+	statusRedirect string
+	statusError    string
+
+	headerName string
+
+	// jsonKey, jsonString, jsonNumber and jsonLiteral color the tokens of a JSON body; literals are true, false
+	// and null
+	jsonKey     string
+	jsonString  string
+	jsonNumber  string
+	jsonLiteral string
+}
+
+// colorSchemes are the palettes --color-scheme chooses from: bright colors that stand out on a dark background,
+// and darker variants that stay readable on a light one
+var colorSchemes = map[string]*colorScheme{
+	"dark": {
+		statusOK:       "1;92",
+		statusRedirect: "1;96",
+		statusError:    "1;91",
+		headerName:     "96",
+		jsonKey:        "94",
+		jsonString:     "92",
+		jsonNumber:     "93",
+		jsonLiteral:    "95",
+	},
+	"light": {
+		statusOK:       "1;32",
+		statusRedirect: "1;36",
+		statusError:    "1;31",
+		headerName:     "36",
+		jsonKey:        "34",
+		jsonString:     "32",
+		jsonNumber:     "35",
+		jsonLiteral:    "31",
+	},
+}
+
+// paint wraps s in the SGR code; a nil scheme leaves s as is
+func (c *colorScheme) paint(code, s string) string {
+	if c == nil || code == "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// status colors a status line by the class of its status code
+func (c *colorScheme) status(statusCode int, s string) string {
+	if c == nil {
+		return s
+	}
+	switch {
+	case statusCode >= 400:
+		return c.paint(c.statusError, s)
+	case statusCode >= 300:
+		return c.paint(c.statusRedirect, s)
+	default:
+		return c.paint(c.statusOK, s)
+	}
+}
+
+// header colors a header name
+func (c *colorScheme) header(name string) string {
+	if c == nil {
+		return name
+	}
+	return c.paint(c.headerName, name)
+}
+
+// outputColors returns the scheme to color the built-in client's output with, or nil when --color-scheme is not
+// given, the output is not going to a terminal or NO_COLOR is set
+func outputColors(name string) *colorScheme {
+	if name == "" || os.Getenv("NO_COLOR") != "" {
+		return nil
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return colorSchemes[name]
+}
+
+// isJSONResponse reports whether the response declares a JSON body, like application/json or
+// application/problem+json
+func isJSONResponse(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// jsonColorWriter colors the JSON written through it token by token, so that streamed responses are colored as
+// they arrive. Input that is not JSON passes through, if oddly colored.
+type jsonColorWriter struct {
+	w      io.Writer
+	colors *colorScheme
+
+	// containers is the stack of open objects and arrays; expectKey is set where an object key may come next
+	containers []byte
+	expectKey  bool
+
+	// token is the kind of token being written: 0 outside of one, '"' in a string, 'n' in a number or literal
+	token   byte
+	escaped bool
+}
+
+// newJSONColorWriter returns a writer that colors JSON with colors before writing it to w
+func newJSONColorWriter(w io.Writer, colors *colorScheme) *jsonColorWriter {
+	return &jsonColorWriter{w: w, colors: colors}
+}
+
+func (j *jsonColorWriter) Write(p []byte) (int, error) {
+	var out strings.Builder
+	for _, b := range p {
+		// Finish the number or literal at the first byte that cannot be part of it
+		if j.token == 'n' && !strings.ContainsRune("0123456789+-.eEtruefalsn", rune(b)) {
+			out.WriteString("\x1b[0m")
+			j.token = 0
+		}
+
+		switch {
+		case j.token == '"':
+			out.WriteByte(b)
+			switch {
+			case j.escaped:
+				j.escaped = false
+			case b == '\\':
+				j.escaped = true
+			case b == '"':
+				out.WriteString("\x1b[0m")
+				j.token = 0
+			}
+			continue
+		case j.token == 'n':
+			out.WriteByte(b)
+			continue
+		}
+
+		switch b {
+		case '"':
+			code := j.colors.jsonString
+			if j.expectKey {
+				code = j.colors.jsonKey
+			}
+			fmt.Fprintf(&out, "\x1b[%sm", code)
+			j.token = '"'
+		case '{', '[':
+			j.containers = append(j.containers, b)
+			j.expectKey = b == '{'
+		case '}', ']':
+			if len(j.containers) > 0 {
+				j.containers = j.containers[:len(j.containers)-1]
+			}
+			j.expectKey = false
+		case ',':
+			j.expectKey = len(j.containers) > 0 && j.containers[len(j.containers)-1] == '{'
+		case ':':
+			j.expectKey = false
+		case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			fmt.Fprintf(&out, "\x1b[%sm", j.colors.jsonNumber)
+			j.token = 'n'
+		case 't', 'f', 'n':
+			fmt.Fprintf(&out, "\x1b[%sm", j.colors.jsonLiteral)
+			j.token = 'n'
+		}
+		out.WriteByte(b)
+	}
+
+	if _, err := io.WriteString(j.w, out.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close resets the color if the input ended inside a token
+func (j *jsonColorWriter) Close() error {
+	if j.token == 0 {
+		return nil
+	}
+	j.token = 0
+	_, err := io.WriteString(j.w, "\x1b[0m")
+	return err
+}
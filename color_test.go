@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONColorWriter(t *testing.T) {
+	colors := &colorScheme{jsonKey: "K", jsonString: "S", jsonNumber: "N", jsonLiteral: "L"}
+	input := `{"name": "a\"b", "ports": [80, -1.5e3], "ok": true, "next": null, "meta": {"x": "y"}}`
+	expected := "{\x1b[Km\"name\"\x1b[0m: \x1b[Sm\"a\\\"b\"\x1b[0m, \x1b[Km\"ports\"\x1b[0m: [\x1b[Nm80\x1b[0m, \x1b[Nm-1.5e3\x1b[0m], " +
+		"\x1b[Km\"ok\"\x1b[0m: \x1b[Lmtrue\x1b[0m, \x1b[Km\"next\"\x1b[0m: \x1b[Lmnull\x1b[0m, " +
+		"\x1b[Km\"meta\"\x1b[0m: {\x1b[Km\"x\"\x1b[0m: \x1b[Sm\"y\"\x1b[0m}}"
+
+	// Write one byte at a time, as a streamed body might arrive
+	var out strings.Builder
+	writer := newJSONColorWriter(&out, colors)
+	for i := range len(input) {
+		if _, err := writer.Write([]byte{input[i]}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	writer.Close()
+	if out.String() != expected {
+		t.Errorf("Expected:\n%q\ngot:\n%q", expected, out.String())
+	}
+
+	// A number at the very end is only finished by Close
+	out.Reset()
+	writer = newJSONColorWriter(&out, colors)
+	writer.Write([]byte("42"))
+	writer.Close()
+	if out.String() != "\x1b[Nm42\x1b[0m" {
+		t.Errorf("Expected the color to be reset on Close, got %q", out.String())
+	}
+}
+
+func TestColorSchemeStatus(t *testing.T) {
+	dark := colorSchemes["dark"]
+	testCases := map[int]string{200: dark.statusOK, 301: dark.statusRedirect, 404: dark.statusError, 503: dark.statusError}
+	for statusCode, code := range testCases {
+		if got := dark.status(statusCode, "status"); got != "\x1b["+code+"mstatus\x1b[0m" {
+			t.Errorf("Unexpected color for %d: %q", statusCode, got)
+		}
+	}
+
+	var none *colorScheme
+	if got := none.status(200, "200 OK") + none.header("Content-Type"); got != "200 OKContent-Type" {
+		t.Errorf("Expected no colors without a scheme, got %q", got)
+	}
+	if colorSchemes["light"].jsonKey == dark.jsonKey {
+		t.Errorf("Expected the light scheme to use other colors than the dark one")
+	}
+}
+
+func TestIsJSONResponse(t *testing.T) {
+	testCases := map[string]bool{
+		"application/json":                true,
+		"application/json; charset=utf-8": true,
+		"application/problem+json":        true,
+		"text/html":                       false,
+		"":                                false,
+	}
+	for contentType, expected := range testCases {
+		header := http.Header{"Content-Type": []string{contentType}}
+		if got := isJSONResponse(header); got != expected {
+			t.Errorf("Expected %v for %q, got %v", expected, contentType, got)
+		}
+	}
+}
+
+func TestOutputColorsNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	if outputColors("light") != nil {
+		t.Errorf("Expected no colors with NO_COLOR set")
+	}
+}
+
+func TestOutputColorsNoScheme(t *testing.T) {
+	if outputColors("") != nil {
+		t.Errorf("Expected no colors without --color-scheme")
+	}
+}
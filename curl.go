@@ -1,48 +1,556 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
+// requestOptions holds the curl options the built-in HTTP client understands
+type requestOptions struct {
+	method                      string
+	headers                     []string
+	data, dataAscii, dataBinary string
+	form                        []string
+	uploadFile                  string
+	verbose                     bool
+	insecure                    bool
+	caCert                      string
+	cert, key                   string
+	user                        string
+	oauth2Bearer                string
+	timeout                     time.Duration
+	connectTimeout              time.Duration
+	followRedirects             bool
+	maxRedirects                int
+	userAgent                   string
+	requestTarget               string
+	ignoreContentLength         bool
+	noKeepalive                 bool
+	compressed                  bool
+	includeHeaders              bool
+	onlyHeaders                 bool
+	output                      string
+
+	// stdout is where the response goes without output; nil means os.Stdout
+	stdout io.Writer
+
+	// transport, when set, is used instead of a transport made for the request, so that repeated requests
+	// share its connections
+	transport http.RoundTripper
+
+	// hexDump prints the body as a hex dump, and base64 encoded as base64, instead of as is
+	hexDump bool
+	base64  bool
+
+	// writeOut is the -w format printed once the transfer is done
+	writeOut string
+
+	// truncate, when positive, prints only the first truncate bytes of the body
+	truncate int64
+
+	// bufferSize is the size of the buffer the body is copied to the output through; zero means
+	// defaultBufferSize
+	bufferSize int
+
+	// tee is a file the response is written to as well
+	tee string
+
+	// colors, when set, colors the status line, header names and JSON bodies
+	colors *colorScheme
+
+	// retry is how many times a transient failure is retried, waiting retryDelay in between. A zero retryDelay
+	// waits one second and doubles the wait after each retry, like curl.
+	retry      int
+	retryDelay time.Duration
+
+	// formType forces -F values to be sent as "multipart" or "urlencoded"; by default file uploads use multipart
+	formType string
+
+	// outputFormat "json" prints the response as a JSON envelope instead of the raw body
+	outputFormat string
+
+	// formatResponse prints the response through this template instead of the raw body, with the pod and
+	// namespace behind the port-forward available to it
+	formatResponse *template.Template
+	pod, namespace string
+
+	// outputTemplate, when set, names the file the response is written to, with the service URL and index of the
+	// request available to it along with the pod, namespace and status code
+	outputTemplate *template.Template
+	url            string
+	index          int
+
+	// fail returns an HTTPError instead of printing the response when the server answers with 400 or above, like
+	// curl's --fail
+	fail bool
+
+	// exitCodes maps response status codes to exit codes; a response with one of them is printed and then
+	// returned as an HTTPError carrying its exit code
+	exitCodes map[int]int
+
+	// assertStatus lists the statuses the response must have; any other one is returned as an AssertionError
+	// once the response is printed
+	assertStatus []int
+
+	// assertBodyContains is a string the response body must contain
+	assertBodyContains string
+
+	// assertHeaders are headers the response must have
+	assertHeaders []headerAssertion
+
+	// stats, when set, is filled in once a response is received
+	stats *responseStats
+
+	// http2 negotiates HTTP/2 over TLS, like curl's --http2; http2PriorKnowledge speaks only HTTP/2, over plain http
+	// too, like --http2-prior-knowledge
+	http2               bool
+	http2PriorKnowledge bool
+
+	// grpc sends the request over HTTP/2, with prior knowledge for plain http, and prints the response trailers
+	// to stderr
+	grpc bool
+
+	// sse asks for a Server-Sent Events stream and prints the data of each event instead of the raw body, only
+	// for events of type sseEvent when set
+	sse      bool
+	sseEvent string
+
+	// ndjson prints each value of a newline-delimited JSON body as it arrives, indented with jsonPP and passed
+	// through jq when set
+	ndjson bool
+	jsonPP bool
+	jq     *jqFilter
+}
+
+// defaultBufferSize is the --buffer-size used when the flag is not given, the same as io.Copy's
+const defaultBufferSize = 32 * 1024
+
+// responseStats describes a received response for --after-request
+type responseStats struct {
+	StatusCode int
+	// Bytes is the size of the body as read, which is 0 when it was not printed
+	Bytes    int64
+	Duration time.Duration
+}
+
+// byteCounter counts the bytes read through it
+type byteCounter struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// compressedAcceptEncoding is the Accept-Encoding --compressed asks for: the encodings decompressBody can decode
+const compressedAcceptEncoding = "gzip, deflate"
+
+// decompressingReader decodes the body of a --compressed response as it is read. The decoder is only made on the
+// first read, as it reads the encoding's header, so that empty bodies read as empty.
+type decompressingReader struct {
+	body     io.ReadCloser
+	encoding string
+	decoder  io.Reader
+	err      error
+}
+
+// decompressBody returns the body decoded from its Content-Encoding, gzip or deflate; bodies with no or another
+// encoding are returned as is
+func decompressBody(body io.ReadCloser, encoding string) io.ReadCloser {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding != "gzip" && encoding != "x-gzip" && encoding != "deflate" {
+		return body
+	}
+	return &decompressingReader{body: body, encoding: encoding}
+}
+
+func (d *decompressingReader) Read(p []byte) (int, error) {
+	if d.decoder == nil && d.err == nil {
+		if d.encoding == "deflate" {
+			d.decoder, d.err = zlib.NewReader(d.body)
+		} else {
+			d.decoder, d.err = gzip.NewReader(d.body)
+		}
+		// An empty body is not a broken one
+		if d.err != nil && d.err != io.EOF {
+			d.err = fmt.Errorf("error decompressing %s response: %v", d.encoding, d.err)
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.decoder.Read(p)
+}
+
+func (d *decompressingReader) Close() error {
+	return d.body.Close()
+}
+
+// headerAssertion is an --assert-header check: the response must have the header with a value matching pattern,
+// in which * matches any text
+type headerAssertion struct {
+	name    string
+	pattern string
+}
+
+// check returns a description of how header fails the assertion, or "" when it passes
+func (a headerAssertion) check(header http.Header) string {
+	values := header.Values(a.name)
+	if len(values) == 0 {
+		return fmt.Sprintf("header %s is missing", a.name)
+	}
+
+	quoted := strings.Split(a.pattern, "*")
+	for i := range quoted {
+		quoted[i] = regexp.QuoteMeta(quoted[i])
+	}
+	pattern := regexp.MustCompile("^" + strings.Join(quoted, ".*") + "$")
+	for _, value := range values {
+		if pattern.MatchString(value) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("header %s is %q, expected %q", a.name, strings.Join(values, ", "), a.pattern)
+}
+
+// AssertionError is returned when the response fails the --assert-* checks, with a description of each failure
+type AssertionError struct {
+	Failures []string
+}
+
+func (e *AssertionError) Error() string {
+	return "assertion failed: " + strings.Join(e.Failures, "; ")
+}
+
+// HTTPError is returned for an error response to a request made with fail, or for a response whose status is in
+// exitCodes. ExitCode is the exit code mapped to the status, if any. Reason is the reason phrase the server sent,
+// which may differ from the standard one.
+type HTTPError struct {
+	StatusCode int
+	Reason     string
+	ExitCode   int
+}
+
+func (e *HTTPError) Error() string {
+	reason := e.Reason
+	if reason == "" {
+		reason = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("the requested URL returned error: %d %s", e.StatusCode, reason)
+}
+
+// newHTTPError returns the HTTPError for resp with the exit code mapped to its status
+func newHTTPError(resp *http.Response, exitCodes map[int]int) *HTTPError {
+	// resp.Status is the status code followed by the reason phrase, like "404 Not Found"
+	_, reason, _ := strings.Cut(resp.Status, " ")
+	return &HTTPError{StatusCode: resp.StatusCode, Reason: reason, ExitCode: exitCodes[resp.StatusCode]}
+}
+
+// jsonResponse is the envelope printed by --output-format json
+type jsonResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+	// BodyEncoding is "base64" when the body is not valid UTF-8 and was encoded to fit in a JSON string
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// responseTemplateData is what a --format-response template is executed with
+type responseTemplateData struct {
+	StatusCode int
+	Headers    http.Header
+	Body       string
+	// Timing holds the duration of each phase of the request: dns, connect, tls, first_byte and total
+	Timing    map[string]time.Duration
+	Pod       string
+	Namespace string
+}
+
+// outputTemplateData is what an --output-template gets to name the file of a response with
+type outputTemplateData struct {
+	Pod        string
+	Namespace  string
+	Index      int
+	StatusCode int
+	URL        string
+}
+
+// outputFileName runs the --output-template for a response and creates the directories of the file it names
+func outputFileName(tmpl *template.Template, data outputTemplateData) (string, error) {
+	var name strings.Builder
+	if err := tmpl.Execute(&name, data); err != nil {
+		return "", fmt.Errorf("error executing --output-template template: %v", err)
+	}
+	if name.Len() == 0 {
+		return "", fmt.Errorf("--output-template produced an empty file name")
+	}
+	if err := os.MkdirAll(filepath.Dir(name.String()), 0755); err != nil {
+		return "", fmt.Errorf("error creating output directory: %v", err)
+	}
+	return name.String(), nil
+}
+
+// newTLSConfig returns the TLS configuration for -k, --cacert and --cert/--key, or nil when none of them is given.
+// -k skips verification altogether, so it makes --cacert moot.
+func newTLSConfig(opts requestOptions) (*tls.Config, error) {
+	if !opts.insecure && opts.caCert == "" && opts.cert == "" {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: opts.insecure}
+
+	if opts.caCert != "" && !opts.insecure {
+		pem, err := os.ReadFile(opts.caCert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificate: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", opts.caCert)
+		}
+	}
+
+	// Like curl, --cert may hold the private key as well when --key is not given
+	if opts.cert != "" {
+		key := cmp.Or(opts.key, opts.cert)
+		cert, err := tls.LoadX509KeyPair(opts.cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// newTransport returns the transport for the request's TLS, protocol and connection options, or nil for the
+// default one
+func newTransport(opts requestOptions) (http.RoundTripper, error) {
+	var roundTripper http.RoundTripper
+
+	// Configure -k, --cacert and --cert if requested
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		roundTripper = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	// --http2 negotiates HTTP/2 over TLS, keeping HTTP/1.1 for plain http and servers that do not offer it.
+	// --http2-prior-knowledge and gRPC speak HTTP/2 only, which over plain http means h2c without an upgrade.
+	if opts.http2 || opts.http2PriorKnowledge || opts.grpc {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig
+		}
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		if opts.http2PriorKnowledge || opts.grpc {
+			transport.Protocols.SetUnencryptedHTTP2(true)
+		} else {
+			transport.Protocols.SetHTTP1(true)
+		}
+		roundTripper = transport
+	}
+
+	// Open a new connection for every request, retries and redirects included
+	if opts.noKeepalive {
+		transport, ok := roundTripper.(*http.Transport)
+		if !ok {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		transport.DisableKeepAlives = true
+		roundTripper = transport
+	}
+
+	// Bound setting up the connection, TCP and TLS, separately from the whole request's timeout
+	if opts.connectTimeout > 0 {
+		transport, ok := roundTripper.(*http.Transport)
+		if !ok {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		dialer := &net.Dialer{Timeout: opts.connectTimeout}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = opts.connectTimeout
+		roundTripper = transport
+	}
+
+	// Read the body until the server closes the connection, whatever its Content-Length says
+	if opts.ignoreContentLength {
+		roundTripper = &ignoreContentLengthTransport{tlsConfig: tlsConfig, connectTimeout: opts.connectTimeout}
+	}
+	return roundTripper, nil
+}
+
+// newPipelineTransport returns the transport --pipeline sends all the --repeat requests through, which keeps a
+// single connection open and reuses it for each of them
+func newPipelineTransport(opts requestOptions) (*http.Transport, error) {
+	roundTripper, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	transport.MaxConnsPerHost = 1
+	transport.MaxIdleConnsPerHost = 1
+	return transport, nil
+}
+
+// formatWriteOut expands the %{name} variables of a -w format with vars, leaving unknown ones as they are. Like
+// curl, %% is a literal % and \n, \r and \t are a newline, carriage return and tab.
+func formatWriteOut(format string, vars map[string]string) string {
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		rest := format[i:]
+		switch {
+		case strings.HasPrefix(rest, "%{"):
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				out.WriteString(rest)
+				return out.String()
+			}
+			if value, ok := vars[rest[2:end]]; ok {
+				out.WriteString(value)
+			} else {
+				out.WriteString(rest[:end+1])
+			}
+			i += end
+		case strings.HasPrefix(rest, "%%"):
+			out.WriteByte('%')
+			i++
+		case strings.HasPrefix(rest, "\\n"):
+			out.WriteByte('\n')
+			i++
+		case strings.HasPrefix(rest, "\\r"):
+			out.WriteByte('\r')
+			i++
+		case strings.HasPrefix(rest, "\\t"):
+			out.WriteByte('\t')
+			i++
+		default:
+			out.WriteByte(format[i])
+		}
+	}
+	return out.String()
+}
+
 // makeHTTPRequest handles the actual HTTP request with all the specified options
-func makeHTTPRequest(url string, method string, headers []string, data string, dataAscii string, dataBinary string,
-	form []string, verbose bool, insecure bool, user string, timeout int, followRedirects bool, maxRedirects int,
-	userAgent string, includeHeaders bool, onlyHeaders bool, output string) error {
+func makeHTTPRequest(ctx context.Context, url string, opts requestOptions) error {
+	// The method and headers may be adjusted below for form data
+	method, headers := opts.method, opts.headers
 
 	// Determine request body
 	var requestBody io.Reader
-	if data != "" {
-		requestBody = strings.NewReader(data)
-	} else if dataAscii != "" {
-		requestBody = strings.NewReader(dataAscii) // Same as -d for ASCII data
-	} else if dataBinary != "" {
-		requestBody = strings.NewReader(dataBinary) // Same as -d for binary data (as string)
+	if opts.data != "" {
+		requestBody = strings.NewReader(opts.data)
+	} else if opts.dataAscii != "" {
+		requestBody = strings.NewReader(opts.dataAscii) // Same as -d for ASCII data
+	} else if opts.dataBinary != "" {
+		requestBody = strings.NewReader(opts.dataBinary) // Same as -d for binary data (as string)
+	}
+
+	// Stream the -T file as the body without reading it into memory; - uploads stdin
+	var uploadSize int64
+	if opts.uploadFile == "-" {
+		requestBody = os.Stdin
+	} else if opts.uploadFile != "" {
+		file, err := os.Open(opts.uploadFile)
+		if err != nil {
+			return fmt.Errorf("error opening upload file: %v", err)
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("error reading upload file: %v", err)
+		}
+		requestBody, uploadSize = file, info.Size()
 	}
 
 	// Handle form data
-	if len(form) > 0 {
-		// Simple implementation: join form data with &
-		formData := strings.Join(form, "&")
-		requestBody = strings.NewReader(formData)
+	if len(opts.form) > 0 {
+		// --form-type overrides picking the encoding from the values
+		multipartForm := formNeedsMultipart(opts.form)
+		switch opts.formType {
+		case "multipart":
+			multipartForm = true
+		case "urlencoded":
+			if multipartForm {
+				return fmt.Errorf("cannot upload files with -F name=@path when --form-type is urlencoded")
+			}
+		}
+
+		if multipartForm {
+			body, contentType, err := buildMultipartForm(opts.form)
+			if err != nil {
+				return err
+			}
+			requestBody = body
+			headers = append(headers, "Content-Type: "+contentType)
+		} else {
+			// Simple implementation: join form data with &
+			formData := strings.Join(opts.form, "&")
+			requestBody = strings.NewReader(formData)
+			// Add content-type for form data
+			headers = append(headers, "Content-Type: application/x-www-form-urlencoded")
+		}
 		if method == "GET" || method == "HEAD" {
 			method = "POST" // Form submission defaults to POST
 		}
-		// Add content-type for form data
-		headers = append(headers, "Content-Type: application/x-www-form-urlencoded")
 	}
 
 	// Create the HTTP request
-	req, err := http.NewRequest(method, url, requestBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, requestBody)
 	if err != nil {
 		return fmt.Errorf("error creating request: %v", err)
 	}
 
+	// A file has a known size, and can be read again to retry the request; stdin is sent chunked
+	if opts.uploadFile != "" && opts.uploadFile != "-" {
+		req.ContentLength = uploadSize
+		req.GetBody = func() (io.ReadCloser, error) {
+			return os.Open(opts.uploadFile)
+		}
+		if uploadSize == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	// Send a different request-target than the URL's path, e.g. * or an absolute URL for proxies
+	if opts.requestTarget != "" {
+		req.URL.Opaque = opts.requestTarget
+	}
+
 	// Add headers
 	for _, header := range headers {
 		parts := strings.SplitN(header, ":", 2)
@@ -52,8 +560,8 @@ func makeHTTPRequest(url string, method string, headers []string, data string, d
 	}
 
 	// Add Authorization header if user is specified
-	if user != "" {
-		parts := strings.SplitN(user, ":", 2)
+	if opts.user != "" {
+		parts := strings.SplitN(opts.user, ":", 2)
 		var username, password string
 		if len(parts) == 2 {
 			username, password = parts[0], parts[1]
@@ -65,13 +573,29 @@ func makeHTTPRequest(url string, method string, headers []string, data string, d
 		req.SetBasicAuth(username, password)
 	}
 
+	// Send the --oauth2-bearer token unless an Authorization header was given explicitly
+	if opts.oauth2Bearer != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+opts.oauth2Bearer)
+	}
+
+	// --compressed asks for a compressed response, which is decompressed below, unless the user asked for
+	// specific encodings
+	if opts.compressed && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", compressedAcceptEncoding)
+	}
+
+	// Ask for an event stream unless the user asked for something else
+	if opts.sse && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+
 	// Add User-Agent header if specified
-	if userAgent != "" {
-		req.Header.Set("User-Agent", userAgent)
+	if opts.userAgent != "" {
+		req.Header.Set("User-Agent", opts.userAgent)
 	}
 
 	// Enable verbose output if requested
-	if verbose {
+	if opts.verbose {
 		fmt.Printf("Making request: %s %s\n", method, url)
 		fmt.Printf("Headers: %v\n", req.Header)
 		if requestBody != nil {
@@ -81,44 +605,122 @@ func makeHTTPRequest(url string, method string, headers []string, data string, d
 		}
 	}
 
-	// Create HTTP client
-	client := &http.Client{}
+	// Time each phase of the request for --format-response
+	timing := map[string]time.Duration{}
+	start := time.Now()
+	if opts.formatResponse != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), requestTrace(start, timing)))
+	}
 
-	// Configure insecure SSL if requested
-	if insecure {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	// Create HTTP client; repeated requests may share a transport to reuse its connection
+	client := &http.Client{Transport: opts.transport}
+	if client.Transport == nil {
+		transport, err := newTransport(opts)
+		if err != nil {
+			return err
 		}
+		client.Transport = transport
 	}
 
 	// Configure timeout if specified
-	if timeout > 0 {
-		client.Timeout = time.Duration(timeout) * time.Second
+	if opts.timeout > 0 {
+		client.Timeout = opts.timeout
 	}
 
 	// Configure redirect behavior
-	if !followRedirects {
+	if !opts.followRedirects {
 		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse // Don't follow redirects
 		}
-	} else if maxRedirects >= 0 {
+	} else if opts.maxRedirects >= 0 {
 		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-			if len(via) >= maxRedirects {
-				return http.ErrUseLastResponse
+			if len(via) > opts.maxRedirects {
+				return fmt.Errorf("maximum (%d) redirects followed", opts.maxRedirects)
 			}
 			return nil
 		}
 	}
 
 	// Execute the HTTP request
-	resp, err := client.Do(req)
+	resp, err := doWithRetry(client, req, opts.retry, opts.retryDelay)
 	if err != nil {
 		return fmt.Errorf("error executing request: %v", err)
 	}
 	defer resp.Body.Close()
 
+	// Record the outcome once the response has been handled, however that ends
+	counter := &byteCounter{ReadCloser: resp.Body}
+	resp.Body = counter
+	if opts.stats != nil {
+		defer func() {
+			*opts.stats = responseStats{StatusCode: resp.StatusCode, Bytes: counter.n, Duration: time.Since(start)}
+		}()
+	}
+
+	// Like curl, -w is written to stdout once the transfer is done, even when it failed or went to a file
+	if opts.writeOut != "" {
+		defer func() {
+			stdout := opts.stdout
+			if stdout == nil {
+				stdout = os.Stdout
+			}
+			fmt.Fprint(stdout, formatWriteOut(opts.writeOut, map[string]string{
+				"http_code":     fmt.Sprintf("%03d", resp.StatusCode),
+				"size_download": strconv.FormatInt(counter.n, 10),
+				"time_total":    fmt.Sprintf("%.6f", time.Since(start).Seconds()),
+				"url_effective": resp.Request.URL.String(),
+				"content_type":  resp.Header.Get("Content-Type"),
+			}))
+		}()
+	}
+
+	// Setting Accept-Encoding stops the transport from decompressing the response itself, so it is done here; the
+	// counter above still counts the bytes as sent, like curl's size_download
+	if opts.compressed {
+		resp.Body = decompressBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	}
+
+	// With --fail an error response is reported instead of printed
+	if opts.fail && resp.StatusCode >= 400 {
+		return newHTTPError(resp, opts.exitCodes)
+	}
+
+	// With --truncate only the start of the body is read; the rest is left for the check below
+	fullBody := resp.Body
+	if opts.truncate > 0 {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, opts.truncate), resp.Body}
+	}
+
+	// Keep a copy of the body as it is printed to check it afterwards
+	var body bytes.Buffer
+	if opts.assertBodyContains != "" {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(resp.Body, &body), resp.Body}
+	}
+
 	// Determine output destination
 	var outputWriter io.Writer = os.Stdout
+	if opts.stdout != nil {
+		outputWriter = opts.stdout
+	}
+	output := opts.output
+	if opts.outputTemplate != nil {
+		output, err = outputFileName(opts.outputTemplate, outputTemplateData{
+			Pod:        opts.pod,
+			Namespace:  opts.namespace,
+			Index:      opts.index,
+			StatusCode: resp.StatusCode,
+			URL:        opts.url,
+		})
+		if err != nil {
+			return err
+		}
+	}
 	if output != "" {
 		file, err := os.Create(output)
 		if err != nil {
@@ -127,32 +729,439 @@ func makeHTTPRequest(url string, method string, headers []string, data string, d
 		defer file.Close()
 		outputWriter = file
 	}
+	if opts.tee != "" {
+		file, err := os.Create(opts.tee)
+		if err != nil {
+			return fmt.Errorf("error creating tee file %s: %v", opts.tee, err)
+		}
+		defer file.Close()
+		outputWriter = io.MultiWriter(outputWriter, file)
+	}
 
-	// Output response headers if requested
-	if includeHeaders || onlyHeaders {
+	// Colors are for the terminal; they would only get in the way in an -o, --output-template or --tee file
+	colors := opts.colors
+	if output != "" || opts.tee != "" {
+		colors = nil
+	}
+
+	// Print the whole response as a JSON envelope for scripts, or through the user's template
+	if opts.outputFormat == "json" {
+		if err := writeJSONResponse(outputWriter, resp, opts.onlyHeaders); err != nil {
+			return err
+		}
+	} else if opts.formatResponse != nil {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading response: %v", err)
+		}
+		timing["total"] = time.Since(start)
+		err = opts.formatResponse.Execute(outputWriter, responseTemplateData{
+			StatusCode: resp.StatusCode,
+			Headers:    resp.Header,
+			Body:       string(body),
+			Timing:     timing,
+			Pod:        opts.pod,
+			Namespace:  opts.namespace,
+		})
+		if err != nil {
+			return fmt.Errorf("error executing --format-response template: %v", err)
+		}
+	} else if opts.includeHeaders || opts.onlyHeaders {
+		// Output response headers if requested
 		for name, values := range resp.Header {
 			for _, value := range values {
-				fmt.Fprintf(outputWriter, "%s: %s\r\n", name, value)
+				fmt.Fprintf(outputWriter, "%s: %s\r\n", colors.header(name), value)
 			}
 		}
-		if includeHeaders {
+		if opts.includeHeaders {
 			fmt.Fprintf(outputWriter, "\r\n") // Add empty line between headers and body
 		}
 	}
 
-	// Copy response to output writer (or skip if only headers requested)
-	if !onlyHeaders {
-		_, err = io.Copy(outputWriter, resp.Body)
+	// Copy response to output writer (or skip if only headers requested or already printed in another format)
+	if !opts.onlyHeaders && opts.outputFormat == "" && opts.formatResponse == nil {
+		// Dump the body as offsets, hex bytes and ASCII like hexdump -C, encode it as base64, or color JSON bodies
+		// for the terminal
+		var encoder io.WriteCloser
+		if opts.hexDump {
+			encoder = hex.Dumper(outputWriter)
+			outputWriter = encoder
+		} else if opts.base64 {
+			encoder = base64.NewEncoder(base64.StdEncoding, outputWriter)
+			outputWriter = encoder
+		} else if colors != nil && (opts.ndjson || isJSONResponse(resp.Header)) {
+			colorWriter := newJSONColorWriter(outputWriter, colors)
+			defer colorWriter.Close()
+			outputWriter = colorWriter
+		}
+
+		if opts.sse {
+			err = writeSSEEvents(outputWriter, resp.Body, opts.sseEvent)
+		} else if opts.ndjson {
+			err = writeNDJSON(outputWriter, resp.Body, opts.jsonPP, opts.jq)
+		} else {
+			// Hide ReadFrom and WriteTo so that the copy goes through a buffer of the requested size
+			buffer := make([]byte, cmp.Or(opts.bufferSize, defaultBufferSize))
+			_, err = io.CopyBuffer(struct{ io.Writer }{outputWriter}, struct{ io.Reader }{resp.Body}, buffer)
+		}
+		// The encoders hold back the last, partial line or block until they are closed
+		if encoder != nil {
+			encoder.Close()
+		}
 		if err != nil {
 			return fmt.Errorf("error reading response: %v", err)
 		}
 	}
 
+	// Say so when there was more body than --truncate let through
+	if opts.truncate > 0 {
+		if n, _ := io.ReadFull(fullBody, make([]byte, 1)); n > 0 {
+			fmt.Fprint(os.Stderr, "\n... [truncated]\n")
+		}
+	}
+
+	// Trailers are only known once the body has been read; for gRPC they carry the call's status
+	if opts.grpc {
+		for name, values := range resp.Trailer {
+			for _, value := range values {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", name, value)
+			}
+		}
+	}
+
 	// Print response status if verbose
-	if verbose {
-		fmt.Printf("\nResponse Status: %s\n", resp.Status)
+	if opts.verbose {
+		fmt.Printf("\nResponse Status: %s\n", opts.colors.status(resp.StatusCode, resp.Status))
 		fmt.Printf("Response Headers: %v\n", resp.Header)
 	}
 
+	if _, ok := opts.exitCodes[resp.StatusCode]; ok {
+		return newHTTPError(resp, opts.exitCodes)
+	}
+
+	// Check the response against the --assert-* flags
+	var failures []string
+	if opts.assertStatus != nil && !slices.Contains(opts.assertStatus, resp.StatusCode) {
+		failures = append(failures, fmt.Sprintf("status %d is not %s", resp.StatusCode, joinInts(opts.assertStatus, " or ")))
+	}
+	if opts.assertBodyContains != "" && !strings.Contains(body.String(), opts.assertBodyContains) {
+		failures = append(failures, fmt.Sprintf("body does not contain %q", opts.assertBodyContains))
+	}
+	for _, assertion := range opts.assertHeaders {
+		if failure := assertion.check(resp.Header); failure != "" {
+			failures = append(failures, failure)
+		}
+	}
+	if failures != nil {
+		return &AssertionError{Failures: failures}
+	}
+
 	return nil
 }
+
+// joinInts formats numbers separated by sep
+func joinInts(numbers []int, sep string) string {
+	s := make([]string, len(numbers))
+	for i, n := range numbers {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, sep)
+}
+
+// writeSSEEvents reads a Server-Sent Events stream from r and writes the data of each event to w on its own line
+// as soon as the event is complete. With eventType set, only events of that type are written.
+func writeSSEEvents(w io.Writer, r io.Reader, eventType string) error {
+	var event string
+	var data []string
+
+	// The scanner buffers lines split across chunks until they are complete
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		// An empty line dispatches the event; events without data are ignored
+		if line == "" {
+			if data != nil && (eventType == "" || eventType == cmp.Or(event, "message")) {
+				if _, err := fmt.Fprintln(w, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+			continue
+		}
+
+		// Lines starting with a colon are comments, often sent as keep-alives
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	return scanner.Err()
+}
+
+// writeJSONResponse reads the response body and writes status, headers and body to w as a single JSON object
+func writeJSONResponse(w io.Writer, resp *http.Response, onlyHeaders bool) error {
+	envelope := jsonResponse{
+		Status:  resp.StatusCode,
+		Headers: resp.Header,
+	}
+
+	if !onlyHeaders {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading response: %v", err)
+		}
+		if utf8.Valid(body) {
+			envelope.Body = string(body)
+		} else {
+			envelope.Body = base64.StdEncoding.EncodeToString(body)
+			envelope.BodyEncoding = "base64"
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(envelope); err != nil {
+		return fmt.Errorf("error writing response: %v", err)
+	}
+	return nil
+}
+
+// requestTrace records the duration of the dns, connect and tls phases into timing, along with the time from
+// start to the first response byte
+func requestTrace(start time.Time, timing map[string]time.Duration) *httptrace.ClientTrace {
+	var dnsStart, connectStart, tlsStart time.Time
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			timing["dns"] = time.Since(dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			timing["connect"] = time.Since(connectStart)
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			timing["tls"] = time.Since(tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			timing["first_byte"] = time.Since(start)
+		},
+	}
+}
+
+// formNeedsMultipart reports whether any -F value uploads a file, which only multipart/form-data can carry
+func formNeedsMultipart(form []string) bool {
+	for _, field := range form {
+		if _, value, _ := strings.Cut(field, "="); strings.HasPrefix(value, "@") {
+			return true
+		}
+	}
+	return false
+}
+
+// buildMultipartForm encodes -F values as a multipart/form-data body, returning it with its Content-Type, which
+// carries the boundary. Like curl, name=@path uploads the file at path and an optional ;type= sets its content type.
+func buildMultipartForm(form []string) (io.Reader, string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, field := range form {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, "", fmt.Errorf("invalid form field %q: expected name=value", field)
+		}
+
+		if !strings.HasPrefix(value, "@") {
+			if err := writer.WriteField(name, value); err != nil {
+				return nil, "", fmt.Errorf("error writing form field %s: %v", name, err)
+			}
+			continue
+		}
+
+		path, contentType, _ := strings.Cut(strings.TrimPrefix(value, "@"), ";type=")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading form file %s: %v", path, err)
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+			"name":     name,
+			"filename": filepath.Base(path),
+		}))
+		header.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", fmt.Errorf("error writing form file %s: %v", path, err)
+		}
+		if _, err := part.Write(content); err != nil {
+			return nil, "", fmt.Errorf("error writing form file %s: %v", path, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", fmt.Errorf("error finishing multipart form: %v", err)
+	}
+	return &body, writer.FormDataContentType(), nil
+}
+
+// ignoreContentLengthTransport sends each request on a new connection with Connection: close and returns a
+// response whose body is everything the server sends until it closes the connection, for servers that send a
+// wrong Content-Length (often 0) with a body. Chunked responses are read as usual.
+type ignoreContentLengthTransport struct {
+	// tlsConfig, when set, is the TLS configuration for -k, --cacert and --cert
+	tlsConfig *tls.Config
+
+	// connectTimeout, when set, bounds the TCP connect and TLS handshake
+	connectTimeout time.Duration
+}
+
+func (t *ignoreContentLengthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		if req.URL.Scheme == "https" {
+			host = net.JoinHostPort(req.URL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+
+	connectCtx := req.Context()
+	if t.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeout(connectCtx, t.connectTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(connectCtx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme == "https" {
+		config := &tls.Config{}
+		if t.tlsConfig != nil {
+			config = t.tlsConfig.Clone()
+		}
+		config.ServerName = req.URL.Hostname()
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(connectCtx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	// Unblock reads and writes when the request is canceled or times out
+	stop := context.AfterFunc(req.Context(), func() { conn.Close() })
+
+	// The end of the body is only known once the server closes the connection
+	req = req.Clone(req.Context())
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+
+	if len(resp.TransferEncoding) == 0 && req.Method != http.MethodHead {
+		// ReadResponse has only consumed the headers; the rest of the stream is the body
+		resp.Body = &connBody{Reader: reader, conn: conn, stop: stop}
+		resp.ContentLength = -1
+	} else {
+		resp.Body = &connBody{Reader: resp.Body, conn: conn, stop: stop}
+	}
+	return resp, nil
+}
+
+// connBody reads a response body straight from its connection and closes the connection with it
+type connBody struct {
+	io.Reader
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *connBody) Close() error {
+	b.stop()
+	return b.conn.Close()
+}
+
+// maxRetryBackoff caps the doubling wait between retries when no --retry-delay is given
+const maxRetryBackoff = 10 * time.Minute
+
+// doWithRetry sends the request, retrying up to retries times on transient failures: timeouts and connection
+// errors, and the 408, 429, 500, 502, 503 and 504 statuses curl's --retry treats as transient
+func doWithRetry(client *http.Client, req *http.Request, retries int, delay time.Duration) (*http.Response, error) {
+	backoff := delay
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	resp, err := client.Do(req)
+	for retriesLeft := retries; retriesLeft > 0 && isTransientFailure(resp, err); retriesLeft-- {
+		// The body has been read by the failed attempt, so it has to be recreated
+		retryReq := req.Clone(req.Context())
+		if req.Body != nil {
+			if req.GetBody == nil {
+				break
+			}
+			if retryReq.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		fmt.Fprintf(os.Stderr, "Warning: transient problem, will retry in %v. %d retries left.\n", backoff, retriesLeft)
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if delay <= 0 {
+			backoff = min(2*backoff, maxRetryBackoff)
+		}
+
+		resp, err = client.Do(retryReq)
+	}
+	return resp, err
+}
+
+// isTransientFailure reports whether a request failure is worth retrying
+func isTransientFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		// A canceled or expired --max-time context is final
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,1546 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"text/template"
+	"time"
+)
+
+func TestMakeHTTPRequestOutputFormatJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "kurl")
+		if r.URL.Path == "/binary" {
+			w.Write([]byte{0xff, 0xfe, 0x00})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		path         string
+		status       int
+		body         string
+		bodyEncoding string
+	}{
+		{"/text", http.StatusCreated, `{"ok":true}`, ""},
+		{"/binary", http.StatusOK, "//4A", "base64"},
+	}
+
+	for _, tc := range testCases {
+		output := filepath.Join(t.TempDir(), "response.json")
+		err := makeHTTPRequest(context.Background(), server.URL+tc.path, requestOptions{
+			method:       "GET",
+			maxRedirects: -1,
+			output:       output,
+			outputFormat: "json",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", tc.path, err)
+		}
+
+		content, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		var got jsonResponse
+		if err := json.Unmarshal(content, &got); err != nil {
+			t.Fatalf("Output for %s is not valid JSON: %v\n%s", tc.path, err, content)
+		}
+		if got.Status != tc.status || got.Body != tc.body || got.BodyEncoding != tc.bodyEncoding {
+			t.Errorf("Unexpected envelope for %s: %+v", tc.path, got)
+		}
+		if got.Headers.Get("X-Test") != "kurl" {
+			t.Errorf("Expected X-Test header in envelope for %s, got %v", tc.path, got.Headers)
+		}
+	}
+}
+
+func TestMakeHTTPRequestFormatResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Version", "1.2")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	if _, _, err := extractKurlFlags([]string{"--format-response", "{{.StatusCode", "http://svc"}); err == nil {
+		t.Errorf("Expected error for invalid template, got nil")
+	}
+
+	opts, _, err := extractKurlFlags([]string{"--format-response",
+		`{{.StatusCode}} {{.Namespace}}/{{.Pod}} {{.Headers.Get "X-Version"}} {{.Body}} {{if .Timing.total}}timed{{end}}`,
+		"http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := filepath.Join(t.TempDir(), "response.txt")
+	err = makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:         "GET",
+		maxRedirects:   -1,
+		output:         output,
+		formatResponse: opts.formatResponse,
+		pod:            "my-app-abc12",
+		namespace:      "my-namespace",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if expected := "200 my-namespace/my-app-abc12 1.2 hello timed"; string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(content))
+	}
+}
+
+func TestMakeHTTPRequestMultipartForm(t *testing.T) {
+	upload := filepath.Join(t.TempDir(), "report.csv")
+	if err := os.WriteFile(upload, []byte("a,b\n1,2\n"), 0644); err != nil {
+		t.Fatalf("Failed to write upload file: %v", err)
+	}
+
+	var method, description, fileName, fileType, fileContent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		// ParseMultipartForm fails unless the Content-Type carries the boundary
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		description = r.FormValue("description")
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		content, _ := io.ReadAll(file)
+		fileName, fileType, fileContent = header.Filename, header.Header.Get("Content-Type"), string(content)
+	}))
+	defer server.Close()
+
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		form:         []string{"description=monthly report", "file=@" + upload + ";type=text/csv"},
+		output:       filepath.Join(t.TempDir(), "response"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if method != http.MethodPost {
+		t.Errorf("Expected POST, got %s", method)
+	}
+	if description != "monthly report" {
+		t.Errorf("Expected description field, got %q", description)
+	}
+	if fileName != "report.csv" || fileType != "text/csv" || fileContent != "a,b\n1,2\n" {
+		t.Errorf("Unexpected file part: name=%q type=%q content=%q", fileName, fileType, fileContent)
+	}
+}
+
+func TestMakeHTTPRequestFormType(t *testing.T) {
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		formType string
+		form     []string
+		expected string
+	}{
+		{"", []string{"a=1", "b=2"}, "application/x-www-form-urlencoded"},
+		{"", []string{"a=1", "file=@" + filepath.Join("testdata", "missing")}, ""},
+		{"multipart", []string{"a=1", "b=2"}, "multipart/form-data"},
+		{"urlencoded", []string{"a=1", "file=@/etc/hosts"}, ""},
+	}
+
+	for _, tc := range testCases {
+		contentType = ""
+		err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+			method:       "POST",
+			maxRedirects: -1,
+			form:         tc.form,
+			formType:     tc.formType,
+			output:       filepath.Join(t.TempDir(), "response"),
+		})
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("Expected error for --form-type %q with %v, got nil", tc.formType, tc.form)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error for --form-type %q: %v", tc.formType, err)
+		}
+		if !strings.HasPrefix(contentType, tc.expected) {
+			t.Errorf("Expected Content-Type %s for --form-type %q, got %q", tc.expected, tc.formType, contentType)
+		}
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--form-type", "json", "http://svc"}); err == nil {
+		t.Errorf("Expected error for invalid --form-type, got nil")
+	}
+}
+
+func TestMakeHTTPRequestRequestTarget(t *testing.T) {
+	var requestURI string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.RequestURI
+	}))
+	// Let OPTIONS * through to the handler instead of being answered by the server itself
+	server.Config.DisableGeneralOptionsHandler = true
+	server.Start()
+	defer server.Close()
+
+	for _, target := range []string{"*", "/other/path?x=1", "http://proxied.example.com/api"} {
+		err := makeHTTPRequest(context.Background(), server.URL+"/api", requestOptions{
+			method:        "OPTIONS",
+			maxRedirects:  -1,
+			requestTarget: target,
+			output:        filepath.Join(t.TempDir(), "response"),
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", target, err)
+		}
+		if requestURI != target {
+			t.Errorf("Expected request-target %q, got %q", target, requestURI)
+		}
+	}
+}
+
+func TestMakeHTTPRequestIgnoreContentLength(t *testing.T) {
+	// A server that claims an empty body but sends one anyway, then closes the connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			http.ReadRequest(bufio.NewReader(conn))
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nactual body"))
+			conn.Close()
+		}
+	}()
+
+	for _, ignore := range []bool{false, true} {
+		output := filepath.Join(t.TempDir(), "response")
+		err := makeHTTPRequest(context.Background(), "http://"+listener.Addr().String()+"/", requestOptions{
+			method:              "GET",
+			maxRedirects:        -1,
+			ignoreContentLength: ignore,
+			output:              output,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error with ignoreContentLength=%v: %v", ignore, err)
+		}
+
+		content, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		expected := ""
+		if ignore {
+			expected = "actual body"
+		}
+		if string(content) != expected {
+			t.Errorf("Expected body %q with ignoreContentLength=%v, got %q", expected, ignore, string(content))
+		}
+	}
+}
+
+func TestFormNeedsMultipart(t *testing.T) {
+	testCases := []struct {
+		form     []string
+		expected bool
+	}{
+		{[]string{"key=value"}, false},
+		{[]string{"a=1", "b=2"}, false},
+		{[]string{"key=@file.txt"}, true},
+		{[]string{"a=1", "upload=@report.csv;type=text/csv"}, true},
+		{[]string{"email=user@example.com"}, false},
+		{nil, false},
+	}
+
+	for _, tc := range testCases {
+		if got := formNeedsMultipart(tc.form); got != tc.expected {
+			t.Errorf("formNeedsMultipart(%q) = %v; expected %v", tc.form, got, tc.expected)
+		}
+	}
+}
+
+func TestMakeHTTPRequestRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= 2 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("finally"))
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "response")
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "POST",
+		data:         "payload",
+		maxRedirects: -1,
+		retry:        3,
+		retryDelay:   time.Millisecond,
+		output:       output,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if string(content) != "finally" {
+		t.Errorf("Expected the body of the successful attempt, got %q", string(content))
+	}
+
+	retry, delay := extractRetry([]string{"--retry", "3", "--retry-delay=0.5", "http://svc"})
+	if retry != 3 || delay != 500*time.Millisecond {
+		t.Errorf("Expected --retry 3 and --retry-delay 0.5s, got %d and %v", retry, delay)
+	}
+}
+
+func TestMakeHTTPRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		timeout:      time.Second,
+		output:       filepath.Join(t.TempDir(), "response"),
+	})
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("Expected a timeout error, got nil")
+	}
+	if !strings.Contains(strings.ToLower(err.Error()), "timeout") {
+		t.Errorf("Expected the error to mention the timeout, got: %v", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("Expected the request to give up after about 1s, took %v", elapsed)
+	}
+}
+
+func TestMakeHTTPRequestConnectTimeout(t *testing.T) {
+	// The listener accepts connections but never answers, so the TLS handshake hangs
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	for _, ignoreContentLength := range []bool{false, true} {
+		start := time.Now()
+		err := makeHTTPRequest(context.Background(), "https://"+listener.Addr().String(), requestOptions{
+			method:              "GET",
+			maxRedirects:        -1,
+			timeout:             5 * time.Second,
+			connectTimeout:      100 * time.Millisecond,
+			ignoreContentLength: ignoreContentLength,
+		})
+		if err == nil {
+			t.Fatalf("Expected a connect timeout error, got nil")
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the connect timeout to fire before the request timeout, took %v (ignoreContentLength=%v)", elapsed, ignoreContentLength)
+		}
+	}
+}
+
+func TestMakeHTTPRequestRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("moved here"))
+	})
+	// /chain/10 redirects to /chain/9 and so on down to /new
+	mux.HandleFunc("/chain/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n <= 1 {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/chain/%d", n-1), http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	request := func(path string, followRedirects bool, maxRedirects int) (string, error) {
+		output := filepath.Join(t.TempDir(), "response")
+		err := makeHTTPRequest(context.Background(), server.URL+path, requestOptions{
+			method:          "GET",
+			followRedirects: followRedirects,
+			maxRedirects:    maxRedirects,
+			includeHeaders:  true,
+			output:          output,
+		})
+		content, _ := os.ReadFile(output)
+		return string(content), err
+	}
+
+	content, err := request("/old", true, -1)
+	if err != nil {
+		t.Fatalf("Unexpected error following redirect: %v", err)
+	}
+	if !strings.HasSuffix(content, "\r\n\r\nmoved here") {
+		t.Errorf("Expected the final 200 body, got %q", content)
+	}
+
+	content, err = request("/old", false, -1)
+	if err != nil {
+		t.Fatalf("Unexpected error without following redirect: %v", err)
+	}
+	if !strings.Contains(content, "Location: /new\r\n") {
+		t.Errorf("Expected the 302 response headers, got %q", content)
+	}
+
+	if _, err := request("/chain/10", true, 1); err == nil {
+		t.Errorf("Expected error for a 10-redirect chain with maxRedirects=1, got nil")
+	}
+	if _, err := request("/chain/1", true, 1); err != nil {
+		t.Errorf("Expected a single redirect to be followed with maxRedirects=1, got: %v", err)
+	}
+}
+
+func TestMakeHTTPRequestNoKeepalive(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("moved here"))
+	})
+	server := httptest.NewUnstartedServer(mux)
+	var mu sync.Mutex
+	connections := 0
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	// Following a redirect reuses the connection, unless keep-alive is disabled
+	for _, noKeepalive := range []bool{false, true} {
+		mu.Lock()
+		connections = 0
+		mu.Unlock()
+		err := makeHTTPRequest(context.Background(), server.URL+"/old", requestOptions{
+			method:          "GET",
+			followRedirects: true,
+			maxRedirects:    -1,
+			noKeepalive:     noKeepalive,
+			stdout:          io.Discard,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := 1
+		if noKeepalive {
+			expected = 2
+		}
+		mu.Lock()
+		if connections != expected {
+			t.Errorf("Expected %d connections with noKeepalive=%v, got %d", expected, noKeepalive, connections)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestMakeHTTPRequestOAuth2Bearer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name     string
+		opts     requestOptions
+		expected string
+	}{
+		{"bearer", requestOptions{oauth2Bearer: "s3cr3t"}, "Bearer s3cr3t"},
+		{"explicit header wins", requestOptions{oauth2Bearer: "s3cr3t", headers: []string{"authorization: Bearer other"}}, "Bearer other"},
+		{"no token", requestOptions{}, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tc.opts.method, tc.opts.maxRedirects, tc.opts.stdout = "GET", -1, &out
+			if err := makeHTTPRequest(context.Background(), server.URL, tc.opts); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("Expected Authorization %q, got %q", tc.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestMakeHTTPRequestCompressed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		body := []byte(strings.Repeat("compressed hello ", 10))
+		var encoded bytes.Buffer
+		switch r.URL.Query().Get("encoding") {
+		case "gzip":
+			zw := gzip.NewWriter(&encoded)
+			zw.Write(body)
+			zw.Close()
+		case "deflate":
+			zw := zlib.NewWriter(&encoded)
+			zw.Write(body)
+			zw.Close()
+		case "empty":
+			w.Header().Set("Content-Encoding", "gzip")
+			return
+		default:
+			w.Write(body)
+			return
+		}
+		w.Header().Set("Content-Encoding", r.URL.Query().Get("encoding"))
+		w.Write(encoded.Bytes())
+	}))
+	defer server.Close()
+
+	expected := strings.Repeat("compressed hello ", 10)
+	testCases := []struct {
+		encoding string
+		expected string
+	}{
+		{"gzip", expected},
+		{"deflate", expected},
+		{"identity", expected},
+		{"empty", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.encoding, func(t *testing.T) {
+			var out bytes.Buffer
+			err := makeHTTPRequest(context.Background(), server.URL+"?encoding="+tc.encoding, requestOptions{
+				method:       "GET",
+				maxRedirects: -1,
+				compressed:   true,
+				stdout:       &out,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("Expected the decompressed body %q, got %q", tc.expected, out.String())
+			}
+		})
+	}
+
+	// The encodings that can be decoded are asked for, unless -H gives an Accept-Encoding
+	for headers, acceptEncoding := range map[string]string{"": compressedAcceptEncoding, "Accept-Encoding: identity": "identity"} {
+		var out bytes.Buffer
+		err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+			method:         "GET",
+			maxRedirects:   -1,
+			compressed:     true,
+			headers:        []string{headers},
+			includeHeaders: true,
+			stdout:         &out,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(out.String(), "X-Accept-Encoding: "+acceptEncoding+"\r\n") {
+			t.Errorf("Expected Accept-Encoding %q to be sent, got %q", acceptEncoding, out.String())
+		}
+	}
+}
+
+func TestMakeHTTPRequestInsecureSkipVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure hello"))
+	}))
+	defer server.Close()
+
+	request := func(insecure bool) (string, error) {
+		output := filepath.Join(t.TempDir(), "response")
+		err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+			method:       "GET",
+			maxRedirects: -1,
+			insecure:     insecure,
+			output:       output,
+		})
+		content, _ := os.ReadFile(output)
+		return string(content), err
+	}
+
+	// The test server's certificate is not signed by a trusted CA
+	if _, err := request(false); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("Expected a certificate error without insecure, got: %v", err)
+	}
+
+	content, err := request(true)
+	if err != nil {
+		t.Fatalf("Unexpected error with insecure: %v", err)
+	}
+	if content != "secure hello" {
+		t.Errorf("Expected the response body, got %q", content)
+	}
+}
+
+// writeTestCert writes the PEM of a self-signed client certificate and of its key to dir, returning the files
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kurl-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile, cert
+}
+
+func TestMakeHTTPRequestCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure hello"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caCert := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	otherCert, _, _ := writeTestCert(t, dir)
+
+	request := func(opts requestOptions) (string, error) {
+		var out bytes.Buffer
+		opts.method, opts.maxRedirects, opts.stdout = "GET", -1, &out
+		err := makeHTTPRequest(context.Background(), server.URL, opts)
+		return out.String(), err
+	}
+
+	// The server's own certificate is the CA that signed it
+	content, err := request(requestOptions{caCert: caCert})
+	if err != nil {
+		t.Fatalf("Unexpected error with --cacert: %v", err)
+	}
+	if content != "secure hello" {
+		t.Errorf("Expected the response body, got %q", content)
+	}
+
+	// Only the given CA is trusted
+	if _, err := request(requestOptions{caCert: otherCert}); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("Expected a certificate error with another CA, got: %v", err)
+	}
+
+	// -k wins over --cacert, even one that cannot be read
+	if _, err := request(requestOptions{caCert: filepath.Join(dir, "missing.crt"), insecure: true}); err != nil {
+		t.Errorf("Unexpected error with --insecure and --cacert: %v", err)
+	}
+	if _, err := request(requestOptions{caCert: filepath.Join(dir, "missing.crt")}); err == nil {
+		t.Errorf("Expected an error for a missing CA file, got nil")
+	}
+}
+
+func TestMakeHTTPRequestClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeTestCert(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+
+	// --cert may hold the key too when --key is not given
+	combined := filepath.Join(dir, "client.pem")
+	certPEM, _ := os.ReadFile(certFile)
+	keyPEM, _ := os.ReadFile(keyFile)
+	os.WriteFile(combined, append(certPEM, keyPEM...), 0600)
+
+	testCases := []struct {
+		name string
+		opts requestOptions
+	}{
+		{"cert and key", requestOptions{caCert: caCert, cert: certFile, key: keyFile}},
+		{"combined file", requestOptions{caCert: caCert, cert: combined}},
+		{"ignore content length", requestOptions{caCert: caCert, cert: certFile, key: keyFile, ignoreContentLength: true}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tc.opts.method, tc.opts.maxRedirects, tc.opts.stdout = "GET", -1, &out
+			if err := makeHTTPRequest(context.Background(), server.URL, tc.opts); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != "kurl-test-client" {
+				t.Errorf("Expected the server to see the client certificate, got %q", out.String())
+			}
+		})
+	}
+
+	// Without the client certificate the handshake fails
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{method: "GET", maxRedirects: -1, caCert: caCert, stdout: io.Discard})
+	if err == nil {
+		t.Errorf("Expected an error without a client certificate, got nil")
+	}
+}
+
+func TestMakeHTTPRequestOutput(t *testing.T) {
+	const body = "line one\nline two\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	output := filepath.Join(os.TempDir(), fmt.Sprintf("test_output_%d", rand.Int64()))
+	t.Cleanup(func() {
+		os.Remove(output)
+	})
+
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		output:       output,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if string(content) != body {
+		t.Errorf("Expected output file to contain %q, got %q", body, string(content))
+	}
+}
+
+func TestMakeHTTPRequestOutputTemplate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	tmpl := template.Must(template.New("output-template").Parse(dir + "/{{.Namespace}}/response_{{.Pod}}_{{.Index}}_{{.StatusCode}}.json"))
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:         "GET",
+		maxRedirects:   -1,
+		outputTemplate: tmpl,
+		pod:            "my-pod",
+		namespace:      "default",
+		url:            "http://my-service/",
+		index:          2,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The directory named by the template is created
+	content, err := os.ReadFile(filepath.Join(dir, "default", "response_my-pod_2_202.json"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if string(content) != `{"ok":true}` {
+		t.Errorf("Expected the response body, got %q", string(content))
+	}
+
+	// A template that names no file is an error
+	tmpl = template.Must(template.New("output-template").Parse(`{{if eq .URL "x"}}x{{end}}`))
+	err = makeHTTPRequest(context.Background(), server.URL, requestOptions{method: "GET", maxRedirects: -1, outputTemplate: tmpl})
+	if err == nil || !strings.Contains(err.Error(), "empty file name") {
+		t.Errorf("Expected an empty file name error, got %v", err)
+	}
+}
+
+func TestMakeHTTPRequestOutputTemplateNoColors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"n":1}`))
+	}))
+	defer server.Close()
+
+	// Colors picked for a terminal stay out of the files, headers included
+	dir := t.TempDir()
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:         "GET",
+		maxRedirects:   -1,
+		includeHeaders: true,
+		colors:         colorSchemes["dark"],
+		outputTemplate: template.Must(template.New("output-template").Parse(dir + "/response_{{.Index}}.json")),
+		index:          1,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "response_1.json"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if bytes.Contains(content, []byte("\x1b[")) {
+		t.Errorf("Expected no color codes in the file, got %q", content)
+	}
+	if !bytes.HasSuffix(content, []byte(`{"ok":true,"n":1}`)) {
+		t.Errorf("Expected the response body, got %q", content)
+	}
+}
+
+func TestMakeHTTPRequestUploadFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	upload := filepath.Join(t.TempDir(), "upload.bin")
+	if err := os.WriteFile(upload, content, 0600); err != nil {
+		t.Fatalf("Failed to write upload file: %v", err)
+	}
+
+	// The first attempt fails, so the retry has to send the file again
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(content)) {
+			t.Errorf("Expected Content-Length %d, got %d", len(content), r.ContentLength)
+		}
+		if !bytes.Equal(body, content) {
+			t.Errorf("Expected the %d bytes of the file, got %d bytes", len(content), len(body))
+		}
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "PUT",
+		maxRedirects: -1,
+		uploadFile:   upload,
+		retry:        1,
+		retryDelay:   time.Millisecond,
+		stdout:       io.Discard,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+
+	err = makeHTTPRequest(context.Background(), server.URL, requestOptions{method: "PUT", maxRedirects: -1, uploadFile: filepath.Join(t.TempDir(), "missing")})
+	if err == nil {
+		t.Errorf("Expected an error for a missing upload file, got nil")
+	}
+}
+
+// writeSizeRecorder records the size of the largest write to it
+type writeSizeRecorder struct {
+	bytes.Buffer
+	largest int
+}
+
+func (w *writeSizeRecorder) Write(p []byte) (int, error) {
+	w.largest = max(w.largest, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestMakeHTTPRequestBufferSize(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 100*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		bufferSize int
+		largest    int
+	}{
+		{0, defaultBufferSize},
+		{1000, 1000},
+		{64 * 1024, 64 * 1024},
+	} {
+		out := &writeSizeRecorder{}
+		err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+			method:       "GET",
+			maxRedirects: -1,
+			bufferSize:   tc.bufferSize,
+			stdout:       out,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(out.Bytes(), body) {
+			t.Errorf("Expected the %d bytes of the body, got %d", len(body), out.Len())
+		}
+		if out.largest > tc.largest {
+			t.Errorf("Expected writes of at most %d bytes with bufferSize %d, got %d", tc.largest, tc.bufferSize, out.largest)
+		}
+	}
+}
+
+func TestMakeHTTPRequestTruncate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	// Catch the truncation note on stderr
+	stderrFile, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatalf("Failed to create stderr file: %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = stderrFile
+	defer func() { os.Stderr = stderr }()
+
+	for _, tc := range []struct {
+		truncate  int64
+		expected  string
+		truncated bool
+	}{
+		{4, "0123", true},
+		{10, "0123456789", false},
+		{0, "0123456789", false},
+	} {
+		stderrFile.Truncate(0)
+		stderrFile.Seek(0, io.SeekStart)
+
+		var out bytes.Buffer
+		err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+			method:       "GET",
+			maxRedirects: -1,
+			truncate:     tc.truncate,
+			stdout:       &out,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if out.String() != tc.expected {
+			t.Errorf("Expected %q with truncate %d, got %q", tc.expected, tc.truncate, out.String())
+		}
+
+		note, _ := os.ReadFile(stderrFile.Name())
+		if truncated := string(note) == "\n... [truncated]\n"; truncated != tc.truncated {
+			t.Errorf("Expected truncated=%v with truncate %d, got stderr %q", tc.truncated, tc.truncate, string(note))
+		}
+	}
+}
+
+func TestFormatWriteOut(t *testing.T) {
+	vars := map[string]string{"http_code": "200", "size_download": "12"}
+	testCases := []struct {
+		format   string
+		expected string
+	}{
+		{"%{http_code}", "200"},
+		{"%{http_code} %{size_download}\\n", "200 12\n"},
+		{"%{unknown} stays", "%{unknown} stays"},
+		{"100%% done\\t%{http_code}", "100% done\t200"},
+		{"50% off", "50% off"},
+		{"unclosed %{http_code", "unclosed %{http_code"},
+		{`back\slash`, `back\slash`},
+	}
+	for _, tc := range testCases {
+		if got := formatWriteOut(tc.format, vars); got != tc.expected {
+			t.Errorf("formatWriteOut(%q) = %q; expected %q", tc.format, got, tc.expected)
+		}
+	}
+}
+
+func TestMakeHTTPRequestWriteOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := makeHTTPRequest(context.Background(), server.URL+"/path", requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		writeOut:     "\\n%{http_code} %{size_download} %{url_effective} %{content_type} %{time_total}",
+		stdout:       &out,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The format comes after the body
+	body, writeOut, _ := strings.Cut(out.String(), "\n")
+	if body != "hello" {
+		t.Errorf("Expected the body first, got %q", out.String())
+	}
+	fields := strings.Fields(writeOut)
+	expected := []string{"201", "5", server.URL + "/path", "text/plain"}
+	if len(fields) != 5 || !reflect.DeepEqual(fields[:4], expected) {
+		t.Fatalf("Expected %v and a time, got %q", expected, writeOut)
+	}
+	if seconds, err := strconv.ParseFloat(fields[4], 64); err != nil || seconds <= 0 {
+		t.Errorf("Expected a positive time_total, got %q", fields[4])
+	}
+}
+
+func TestMakeHTTPRequestHexDump(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello, \x00\x01binary world\n"))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		hexDump:      true,
+		stdout:       &out,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The last, partial line is written too
+	expected := "00000000  68 65 6c 6c 6f 2c 20 00  01 62 69 6e 61 72 79 20  |hello, ..binary |\n" +
+		"00000010  77 6f 72 6c 64 0a                                 |world.|\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestMakeHTTPRequestBase64(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0x00, 0xff, 'k', 'u', 'r', 'l'})
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		base64:       true,
+		stdout:       &out,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out.String() != "AP9rdXJs" {
+		t.Errorf("Expected the body as base64, got %q", out.String())
+	}
+}
+
+func TestMakeHTTPRequestIncludeHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "kurl")
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	var stdout bytes.Buffer
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:         "GET",
+		maxRedirects:   -1,
+		includeHeaders: true,
+		stdout:         &stdout,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	headers, body, found := strings.Cut(stdout.String(), "\r\n\r\n")
+	if !found {
+		t.Fatalf("Expected headers and body separated by an empty line, got %q", stdout.String())
+	}
+	if body != "body" {
+		t.Errorf("Expected body after the headers, got %q", body)
+	}
+	for _, line := range strings.Split(headers, "\r\n") {
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok || name == "" || value == "" {
+			t.Errorf("Expected a 'Name: Value' header line, got %q", line)
+		}
+	}
+	if !strings.Contains(headers+"\r\n", "X-Test: kurl\r\n") {
+		t.Errorf("Expected the X-Test header, got %q", headers)
+	}
+}
+
+func TestMakeHTTPRequestTee(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	var stdout bytes.Buffer
+	tee := filepath.Join(t.TempDir(), "response.txt")
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		stdout:       &stdout,
+		tee:          tee,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if stdout.String() != "body" {
+		t.Errorf("Expected the body on stdout, got %q", stdout.String())
+	}
+	content, err := os.ReadFile(tee)
+	if err != nil {
+		t.Fatalf("Failed to read tee file: %v", err)
+	}
+	if string(content) != "body" {
+		t.Errorf("Expected the body in the tee file, got %q", string(content))
+	}
+}
+
+func TestMakeHTTPRequestGRPC(t *testing.T) {
+	// A gRPC server only speaks HTTP/2, over plain http without an upgrade
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("Expected an HTTP/2 request, got %s", r.Proto)
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte(`{"message":"hello"}`))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	var stdout bytes.Buffer
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "POST",
+		headers:      []string{"Content-Type: application/grpc+json"},
+		data:         `{"name":"kurl"}`,
+		maxRedirects: -1,
+		stdout:       &stdout,
+		grpc:         true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stdout.String() != `{"message":"hello"}` {
+		t.Errorf("Expected the response body, got %q", stdout.String())
+	}
+}
+
+func TestMakeHTTPRequestHTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+
+	// h2c speaks HTTP/2 over plain http, but only to clients that start with it
+	h2cServer := httptest.NewUnstartedServer(handler)
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetHTTP1(true)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	defer h2cServer.Close()
+
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	testCases := []struct {
+		name     string
+		url      string
+		opts     requestOptions
+		expected string
+	}{
+		{"prior knowledge", h2cServer.URL, requestOptions{http2PriorKnowledge: true}, "HTTP/2.0"},
+		{"http2 over plain http", h2cServer.URL, requestOptions{http2: true}, "HTTP/1.1"},
+		{"http2 over TLS", tlsServer.URL, requestOptions{http2: true, insecure: true}, "HTTP/2.0"},
+		{"prior knowledge over TLS", tlsServer.URL, requestOptions{http2PriorKnowledge: true, insecure: true}, "HTTP/2.0"},
+		{"insecure without http2", tlsServer.URL, requestOptions{insecure: true}, "HTTP/1.1"},
+	}
+	for _, tc := range testCases {
+		var out bytes.Buffer
+		tc.opts.method, tc.opts.maxRedirects, tc.opts.stdout = "GET", -1, &out
+		if err := makeHTTPRequest(context.Background(), tc.url, tc.opts); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if out.String() != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expected, out.String())
+		}
+	}
+}
+
+func TestWriteSSEEvents(t *testing.T) {
+	stream := "event: update\ndata: first\n\n" +
+		": keep-alive\n\n" +
+		"data: line one\r\ndata: line two\r\n\r\n" +
+		"event: update\nid: 3\ndata:third\n\n" +
+		"data: unterminated\n"
+
+	testCases := []struct {
+		eventType string
+		expected  string
+	}{
+		{"", "first\nline one\nline two\nthird\n"},
+		{"update", "first\nthird\n"},
+		{"message", "line one\nline two\n"},
+	}
+
+	for _, tc := range testCases {
+		// Deliver the stream one byte at a time so lines are split across reads
+		var out bytes.Buffer
+		if err := writeSSEEvents(&out, iotest.OneByteReader(strings.NewReader(stream)), tc.eventType); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if out.String() != tc.expected {
+			t.Errorf("Expected %q for event type %q, got %q", tc.expected, tc.eventType, out.String())
+		}
+	}
+}
+
+func TestMakeHTTPRequestSSE(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("Expected Accept: text/event-stream, got %q", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, data := range []string{"one", "two"} {
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	var stdout bytes.Buffer
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		stdout:       &stdout,
+		sse:          true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stdout.String() != "one\ntwo\n" {
+		t.Errorf("Expected the data of each event, got %q", stdout.String())
+	}
+}
+
+func TestMakeHTTPRequestFail(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fine"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	// A reason phrase of the server's own is reported as sent
+	mux.HandleFunc("/teapot", func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 418 Short And Stout\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		buf.Flush()
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testCases := []struct {
+		path    string
+		message string
+	}{
+		{"/ok", ""},
+		{"/missing", "the requested URL returned error: 404 Not Found"},
+		{"/teapot", "the requested URL returned error: 418 Short And Stout"},
+	}
+	for _, tc := range testCases {
+		var out bytes.Buffer
+		err := makeHTTPRequest(context.Background(), server.URL+tc.path, requestOptions{
+			method:       "GET",
+			maxRedirects: -1,
+			fail:         true,
+			stdout:       &out,
+		})
+		if tc.message == "" {
+			if err != nil || out.String() != "fine" {
+				t.Errorf("%s: expected the body and no error, got %q and %v", tc.path, out.String(), err)
+			}
+			continue
+		}
+
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || err.Error() != tc.message {
+			t.Errorf("%s: expected %q, got %v", tc.path, tc.message, err)
+		}
+		if exitCode(err) != 22 {
+			t.Errorf("%s: expected exit code 22, got %d", tc.path, exitCode(err))
+		}
+		if out.Len() != 0 {
+			t.Errorf("%s: expected no output for an error response, got %q", tc.path, out.String())
+		}
+	}
+}
+
+func TestMakeHTTPRequestAssertStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		assertStatus []int
+		failure      string
+	}{
+		{[]int{202}, ""},
+		{[]int{200, 201, 202}, ""},
+		{[]int{200, 201}, "status 202 is not 200 or 201"},
+	}
+
+	for _, tc := range testCases {
+		var stdout bytes.Buffer
+		err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+			method:       "GET",
+			maxRedirects: -1,
+			stdout:       &stdout,
+			assertStatus: tc.assertStatus,
+		})
+
+		// The response is printed either way
+		if stdout.String() != "body" {
+			t.Errorf("Expected the body to be printed, got %q", stdout.String())
+		}
+		if tc.failure == "" {
+			if err != nil {
+				t.Errorf("Unexpected error for %v: %v", tc.assertStatus, err)
+			}
+			continue
+		}
+		var assertErr *AssertionError
+		if !errors.As(err, &assertErr) || !reflect.DeepEqual(assertErr.Failures, []string{tc.failure}) {
+			t.Errorf("Expected assertion failure %q for %v, got %v", tc.failure, tc.assertStatus, err)
+		}
+	}
+}
+
+func TestMakeHTTPRequestAssertBodyContains(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		substring string
+		hasError  bool
+	}{
+		{`"status":"ok"`, false},
+		{`"status":"down"`, true},
+		{".*", true},
+	}
+
+	for _, tc := range testCases {
+		var stdout bytes.Buffer
+		err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+			method:             "GET",
+			maxRedirects:       -1,
+			stdout:             &stdout,
+			assertBodyContains: tc.substring,
+		})
+		if stdout.String() != `{"status":"ok"}` {
+			t.Errorf("Expected the body to be printed, got %q", stdout.String())
+		}
+		var assertErr *AssertionError
+		if tc.hasError != errors.As(err, &assertErr) {
+			t.Errorf("Expected assertion failure %v for %q, got %v", tc.hasError, tc.substring, err)
+		}
+	}
+}
+
+func TestMakeHTTPRequestAssertHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Request-Id", "42")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name       string
+		assertions []headerAssertion
+		failures   []string
+	}{
+		{
+			name:       "wildcard",
+			assertions: []headerAssertion{{"X-Request-Id", "*"}, {"content-type", "application/json*"}},
+		},
+		{
+			name:       "exact",
+			assertions: []headerAssertion{{"X-Request-Id", "42"}},
+		},
+		{
+			name:       "all failures listed",
+			assertions: []headerAssertion{{"Content-Type", "text/*"}, {"X-Missing", "*"}, {"X-Request-Id", "4"}},
+			failures: []string{
+				`header Content-Type is "application/json; charset=utf-8", expected "text/*"`,
+				"header X-Missing is missing",
+				`header X-Request-Id is "42", expected "4"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+				method:        "GET",
+				maxRedirects:  -1,
+				stdout:        io.Discard,
+				assertHeaders: tc.assertions,
+			})
+			if tc.failures == nil {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			var assertErr *AssertionError
+			if !errors.As(err, &assertErr) || !reflect.DeepEqual(assertErr.Failures, tc.failures) {
+				t.Errorf("Expected failures %q, got %v", tc.failures, err)
+			}
+		})
+	}
+}
+
+func TestMakeHTTPRequestStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	var stats responseStats
+	err := makeHTTPRequest(context.Background(), server.URL, requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		stdout:       io.Discard,
+		stats:        &stats,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.StatusCode != http.StatusCreated || stats.Bytes != 4 || stats.Duration <= 0 {
+		t.Errorf("Expected status 201, 4 bytes and a duration, got %+v", stats)
+	}
+}
+
+func BenchmarkMakeHTTPRequest(b *testing.B) {
+	body := bytes.Repeat([]byte("x"), 1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	opts := requestOptions{
+		method:       "GET",
+		maxRedirects: -1,
+		stdout:       io.Discard,
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := makeHTTPRequest(context.Background(), server.URL, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+}
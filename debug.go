@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// debugf prints a --debug line to stderr
+func debugf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "debug: "+format+"\n", args...)
+}
+
+// debugRoundTripper logs every request made to the Kubernetes API, including the port-forward upgrade
+type debugRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (rt *debugRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	debugf("> %s %s", req.Method, req.URL)
+	for name, values := range req.Header {
+		// Never print credentials, even when debugging
+		if name == "Authorization" {
+			values = []string{"<redacted>"}
+		}
+		for _, value := range values {
+			debugf("> %s: %s", name, value)
+		}
+	}
+
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		debugf("< %s %s failed after %v: %v", req.Method, req.URL, time.Since(start), err)
+		return nil, err
+	}
+
+	debugf("< %s (%v)", resp.Status, time.Since(start))
+	for name, values := range resp.Header {
+		for _, value := range values {
+			debugf("< %s: %s", name, value)
+		}
+	}
+	return resp, nil
+}
+
+// debugDialer logs the port-forward connection and every stream opened on it
+type debugDialer struct {
+	httpstream.Dialer
+}
+
+func (d *debugDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	conn, protocol, err := d.Dialer.Dial(protocols...)
+	if err != nil {
+		debugf("port-forward: dial failed: %v", err)
+		return nil, "", err
+	}
+	debugf("port-forward: connected using protocol %s", protocol)
+	return &debugConnection{Connection: conn}, protocol, nil
+}
+
+// debugConnection logs the streams created on a port-forward connection
+type debugConnection struct {
+	httpstream.Connection
+}
+
+func (c *debugConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	stream, err := c.Connection.CreateStream(headers)
+	if err != nil {
+		debugf("port-forward: creating %s stream for port %s failed: %v", headers.Get("streamType"), headers.Get("port"), err)
+		return nil, err
+	}
+	debugf("port-forward: stream %d created: %s stream for port %s, request %s",
+		stream.Identifier(), headers.Get("streamType"), headers.Get("port"), headers.Get("requestID"))
+	return &debugStream{Stream: stream}, nil
+}
+
+func (c *debugConnection) Close() error {
+	debugf("port-forward: connection closed")
+	return c.Connection.Close()
+}
+
+// debugStream logs the data frames read from and written to a port-forward stream
+type debugStream struct {
+	httpstream.Stream
+}
+
+func (s *debugStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 {
+		debugf("port-forward: stream %d: received %d bytes", s.Identifier(), n)
+	}
+	if err != nil {
+		debugf("port-forward: stream %d: read ended: %v", s.Identifier(), err)
+	}
+	return n, err
+}
+
+func (s *debugStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	debugf("port-forward: stream %d: sent %d bytes", s.Identifier(), n)
+	if err != nil {
+		debugf("port-forward: stream %d: write failed: %v", s.Identifier(), err)
+	}
+	return n, err
+}
+
+func (s *debugStream) Close() error {
+	debugf("port-forward: stream %d closed", s.Identifier())
+	return s.Stream.Close()
+}
+
+func (s *debugStream) Reset() error {
+	debugf("port-forward: stream %d reset", s.Identifier())
+	return s.Stream.Reset()
+}
+
+// portForwardTraffic counts the bytes sent to and received from pods through port-forward data streams
+type portForwardTraffic struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (t *portForwardTraffic) String() string {
+	return fmt.Sprintf("port-forward: sent %d bytes, received %d bytes", t.sent.Load(), t.received.Load())
+}
+
+// countingDialer counts the bytes going through the data streams of its port-forward connections
+type countingDialer struct {
+	httpstream.Dialer
+	traffic *portForwardTraffic
+}
+
+func (d *countingDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	conn, protocol, err := d.Dialer.Dial(protocols...)
+	if err != nil {
+		return nil, "", err
+	}
+	return &countingConnection{Connection: conn, traffic: d.traffic}, protocol, nil
+}
+
+// countingConnection wraps the data streams created on a port-forward connection to count their bytes
+type countingConnection struct {
+	httpstream.Connection
+	traffic *portForwardTraffic
+}
+
+func (c *countingConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	stream, err := c.Connection.CreateStream(headers)
+	if err != nil || headers.Get(corev1.StreamType) != corev1.StreamTypeData {
+		return stream, err
+	}
+	return &countingStream{Stream: stream, traffic: c.traffic}, nil
+}
+
+// countingStream counts the bytes read from and written to a port-forward data stream
+type countingStream struct {
+	httpstream.Stream
+	traffic *portForwardTraffic
+}
+
+func (s *countingStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	s.traffic.received.Add(int64(n))
+	return n, err
+}
+
+func (s *countingStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	s.traffic.sent.Add(int64(n))
+	return n, err
+}
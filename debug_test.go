@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// fakeStream is an in-memory port-forward stream that echoes back what is written to it
+type fakeStream struct {
+	bytes.Buffer
+	headers http.Header
+}
+
+func (s *fakeStream) Close() error         { return nil }
+func (s *fakeStream) Reset() error         { return nil }
+func (s *fakeStream) Headers() http.Header { return s.headers }
+func (s *fakeStream) Identifier() uint32   { return 1 }
+
+// fakeConnection creates fakeStreams
+type fakeConnection struct{}
+
+func (c *fakeConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	return &fakeStream{headers: headers}, nil
+}
+func (c *fakeConnection) Close() error                               { return nil }
+func (c *fakeConnection) CloseChan() <-chan bool                     { return nil }
+func (c *fakeConnection) SetIdleTimeout(timeout time.Duration)       {}
+func (c *fakeConnection) RemoveStreams(streams ...httpstream.Stream) {}
+
+// fakeDialer returns a fakeConnection
+type fakeDialer struct{}
+
+func (d *fakeDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	return &fakeConnection{}, protocols[0], nil
+}
+
+func TestCountingDialer(t *testing.T) {
+	traffic := &portForwardTraffic{}
+	dialer := &countingDialer{Dialer: &fakeDialer{}, traffic: traffic}
+
+	conn, _, err := dialer.Dial("portforward.k8s.io")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Only data streams are counted, not the error stream
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	errorStream, _ := conn.CreateStream(headers)
+	errorStream.Write([]byte("ignored"))
+
+	headers = http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, _ := conn.CreateStream(headers)
+	dataStream.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	io.ReadAll(io.LimitReader(dataStream, 5))
+
+	if expected := "port-forward: sent 18 bytes, received 5 bytes"; traffic.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, traffic.String())
+	}
+}
@@ -8,13 +8,21 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -31,6 +39,23 @@ const resourceTypeDeployment resourceType = "deployments"
 const resourceTypeStatefulSet resourceType = "statefulsets"
 const resourceTypeDaemonSet resourceType = "daemonsets"
 const resourceTypeReplicaSet resourceType = "replicasets"
+const resourceTypeJob resourceType = "jobs"
+
+// resourceTypeAliases maps the resource type segment of a URL host to the resource it names
+var resourceTypeAliases = map[string]resourceType{
+	"svc":         resourceTypeSvc,
+	"service":     resourceTypeSvc,
+	"pod":         resourceTypePod,
+	"deploy":      resourceTypeDeployment,
+	"deployment":  resourceTypeDeployment,
+	"sts":         resourceTypeStatefulSet,
+	"statefulset": resourceTypeStatefulSet,
+	"ds":          resourceTypeDaemonSet,
+	"daemonset":   resourceTypeDaemonSet,
+	"rs":          resourceTypeReplicaSet,
+	"replicaset":  resourceTypeReplicaSet,
+	"job":         resourceTypeJob,
+}
 
 var resourceNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
 
@@ -39,14 +64,61 @@ type forwardTarget struct {
 	name      string
 	kind      resourceType
 	port      int
+
+	// portName is set instead of port when the URL names the port, like http://service.namespace.svc:http
+	portName string
+
+	// implicitNamespace is set when the URL did not name a namespace and "default" was assumed
+	implicitNamespace bool
 }
 
 func (res *forwardTarget) String() string {
 	return fmt.Sprintf("namespaces=%s, name=%s, type=%s, port=%d", res.namespace, res.name, string(res.kind), res.port)
 }
 
+// validateResourceName checks name against resourceNameRegex, explaining which rule it breaks
+func validateResourceName(name string) error {
+	if resourceNameRegex.MatchString(name) {
+		return nil
+	}
+	switch {
+	case name == "":
+		return fmt.Errorf("name must not be empty")
+	case strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-"):
+		return fmt.Errorf("name must start and end with a lowercase letter or digit")
+	case strings.ToLower(name) != name:
+		return fmt.Errorf("name must not contain uppercase letters")
+	default:
+		return fmt.Errorf("name may only contain lowercase letters, digits and '-'")
+	}
+}
+
+// cutNamedPort removes a port name, like the http in http://service.namespace.svc:http/path, from the URL so that
+// it can be parsed, returning the URL without it and the name. URLs with a numeric port or none are returned as is.
+func cutNamedPort(rawURL string) (string, string) {
+	scheme, rest, found := strings.Cut(rawURL, "://")
+	if !found {
+		return rawURL, ""
+	}
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	authority := rest[:end]
+	colon := strings.LastIndex(authority, ":")
+	if colon < 0 || colon < strings.LastIndex(authority, "@") || colon < strings.LastIndex(authority, "]") {
+		return rawURL, ""
+	}
+	name := authority[colon+1:]
+	if name == "" || strings.Trim(name, "0123456789") == "" {
+		return rawURL, ""
+	}
+	return scheme + "://" + authority[:colon] + rest[end:], name
+}
+
 // parseKubernetesServiceURL extracts namespace, service name, and port from a Kubernetes service URL
 func parseKubernetesServiceURL(rawURL string) (*forwardTarget, error) {
+	rawURL, portName := cutNamedPort(rawURL)
 	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid URL: %v", err)
@@ -56,6 +128,7 @@ func parseKubernetesServiceURL(rawURL string) (*forwardTarget, error) {
 	host := strings.Split(parsedURL.Host, ":")[0]
 
 	parts := strings.Split(host, ".")
+	implicitNamespace := false
 
 	switch len(parts) {
 	case 3:
@@ -64,6 +137,7 @@ func parseKubernetesServiceURL(rawURL string) (*forwardTarget, error) {
 	case 1:
 		// service name only. Assume default namespace and svc
 		parts = append(parts, "default", "svc")
+		implicitNamespace = true
 	default:
 		return nil, fmt.Errorf("invalid Kubernetes service URL format: %s", rawURL)
 	}
@@ -71,7 +145,13 @@ func parseKubernetesServiceURL(rawURL string) (*forwardTarget, error) {
 	// Extract port
 	port := 80
 	portStr := parsedURL.Port()
-	if portStr == "" {
+	if portName != "" {
+		// Named ports are looked up on the service or pod once it is known
+		if errs := validation.IsValidPortName(portName); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid port name %q in URL: %s", portName, strings.Join(errs, "; "))
+		}
+		port = 0
+	} else if portStr == "" {
 		// Use default port based on scheme if no port specified
 		if parsedURL.Scheme == "https" {
 			port = 443
@@ -83,51 +163,203 @@ func parseKubernetesServiceURL(rawURL string) (*forwardTarget, error) {
 		if err != nil {
 			return nil, fmt.Errorf("invalid port in URL: %v", err)
 		}
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port in URL: %d is out of range (1-65535)", port)
+		}
 	}
 
 	// Extract service name and namespace
 	resourceName := parts[0]
 	namespace := parts[1]
-	var kind resourceType
-	if parts[2] == "svc" || parts[2] == "service" {
-		kind = resourceTypeSvc
-	} else if parts[2] == "pod" {
-		kind = resourceTypePod
-	} else if parts[2] == "deploy" || parts[2] == "deployment" {
-		kind = resourceTypeDeployment
-	} else if parts[2] == "sts" || parts[2] == "statefulset" {
-		kind = resourceTypeStatefulSet
-	} else if parts[2] == "ds" || parts[2] == "daemonset" {
-		kind = resourceTypeDaemonSet
-	} else if parts[2] == "rs" || parts[2] == "replicaset" {
-		kind = resourceTypeReplicaSet
-	} else {
-		return nil, fmt.Errorf("unsupported resource type: %s (supported: svc/service, pod, deploy/deployment, sts/statefulset, ds/daemonset, rs/replicaset)", parts[2])
+	kind, ok := resourceTypeAliases[parts[2]]
+	if !ok {
+		return nil, fmt.Errorf("unsupported resource type: %s (supported: svc/service, pod, deploy/deployment, sts/statefulset, ds/daemonset, rs/replicaset, job)", parts[2])
 	}
 
 	// Basic validation for service name and namespace
 
-	if !resourceNameRegex.MatchString(resourceName) {
-		return nil, fmt.Errorf("invalid resource name: %s", resourceName)
+	if err := validateResourceName(resourceName); err != nil {
+		return nil, fmt.Errorf("invalid resource name %q: %v", resourceName, err)
+	}
+
+	if err := validateResourceName(namespace); err != nil {
+		return nil, fmt.Errorf("invalid namespace %q: %v", namespace, err)
+	}
+
+	return &forwardTarget{
+		namespace:         namespace,
+		name:              resourceName,
+		kind:              kind,
+		port:              port,
+		portName:          portName,
+		implicitNamespace: implicitNamespace,
+	}, nil
+}
+
+// kubeOptions holds the settings applied to the kubeconfig when talking to the Kubernetes API
+type kubeOptions struct {
+	// kubeconfig is the kubeconfig file to use instead of searching KUBECONFIG and ~/.kube/config
+	kubeconfig string
+
+	// context is the kubeconfig context to use instead of the current one
+	context string
+
+	// impersonate and impersonateGroups set the user and groups the API server should act as
+	impersonate       string
+	impersonateGroups []string
+
+	// serviceAccount, when set, authenticates with a token of that service account instead of the kubeconfig user.
+	// serviceAccountNamespace defaults to the namespace of the current kubeconfig context.
+	serviceAccount          string
+	serviceAccountNamespace string
+
+	// debug logs every API request and the port-forward streams to stderr
+	debug bool
+
+	// traffic, when set, counts the bytes going through the port-forwards
+	traffic *portForwardTraffic
+
+	// apiTimeout bounds each service, pod and workload lookup; zero means no limit. The port-forward stream
+	// itself is not affected.
+	apiTimeout time.Duration
+
+	// portForwardRetries is how many times a port-forward that fails before it is ready is retried
+	portForwardRetries int
+}
+
+// defaultAPITimeout is the --k8s-timeout used when the flag is not given
+const defaultAPITimeout = 10 * time.Second
+
+// defaultPortForwardRetries is how many times a failed port-forward is retried when --retry is not given
+const defaultPortForwardRetries = 3
+
+// portForwardRetryDelay is the wait before the first port-forward retry; it doubles after each further retry
+var portForwardRetryDelay = 500 * time.Millisecond
+
+// getRESTConfig loads the current kubeconfig context, or the one named by opts.context, and applies opts on top of it
+func getRESTConfig(ctx context.Context, opts kubeOptions) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if opts.kubeconfig != "" {
+		loadingRules = &clientcmd.ClientConfigLoadingRules{ExplicitPath: opts.kubeconfig}
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: opts.context},
+	)
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		// Without a kubeconfig, fall back to the service account of the pod kurl runs in, unless a kubeconfig or
+		// context was asked for
+		inCluster, inClusterErr := inClusterConfig()
+		if inClusterErr != nil || opts.kubeconfig != "" || opts.context != "" {
+			return nil, fmt.Errorf("failed to create Kubernetes config: %v", err)
+		}
+		config = inCluster
+	}
+
+	// Swap the kubeconfig credentials for a token of the requested service account
+	if opts.serviceAccount != "" {
+		namespace := opts.serviceAccountNamespace
+		if namespace == "" {
+			if namespace, _, err = clientConfig.Namespace(); err != nil {
+				return nil, fmt.Errorf("failed to determine namespace for service account %s: %v", opts.serviceAccount, err)
+			}
+		}
+
+		clientset, err := getKubernetesClient(config, opts.apiTimeout)
+		if err != nil {
+			return nil, err
+		}
+		lookupCtx, cancel := apiContext(ctx, opts.apiTimeout)
+		defer cancel()
+		token, err := serviceAccountToken(lookupCtx, clientset, namespace, opts.serviceAccount)
+		if err != nil {
+			return nil, err
+		}
+
+		config = rest.AnonymousClientConfig(config)
+		config.BearerToken = token
+	}
+
+	// Log the API requests, including the port-forward upgrade request
+	if opts.debug {
+		config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return &debugRoundTripper{next: rt}
+		})
+	}
+
+	// Act as another user and/or groups, e.g. to verify RBAC rules for port-forward
+	if opts.impersonate != "" {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: opts.impersonate,
+			Groups:   opts.impersonateGroups,
+		}
 	}
 
-	if !resourceNameRegex.MatchString(namespace) {
-		return nil, fmt.Errorf("invalid namespace: %s", namespace)
+	return config, nil
+}
+
+// Paths of the service account credentials mounted into pods; variables so tests can point them elsewhere
+var (
+	inClusterTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// inClusterConfig returns a config that talks to the API server of the cluster kurl runs in as the pod's
+// service account
+func inClusterConfig() (*rest.Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	if _, err := os.Stat(inClusterTokenPath); err != nil {
+		return nil, fmt.Errorf("failed to find service account token: %v", err)
 	}
 
-	return &forwardTarget{namespace, resourceName, kind, port}, nil
+	config := &rest.Config{
+		Host:            "https://" + net.JoinHostPort(host, port),
+		BearerTokenFile: inClusterTokenPath,
+	}
+	if _, err := os.Stat(inClusterCAPath); err == nil {
+		config.TLSClientConfig.CAFile = inClusterCAPath
+	}
+	return config, nil
 }
 
-// getKubernetesClient creates a Kubernetes client using the current kubeconfig context
-func getKubernetesClient() (*kubernetes.Clientset, error) {
-	// Build the client config
-	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		clientcmd.NewDefaultClientConfigLoadingRules(),
-		&clientcmd.ConfigOverrides{},
-	).ClientConfig()
+// serviceAccountToken returns a token for the named service account. It prefers a long-lived token secret linked
+// to the account and falls back to the TokenRequest API, since clusters on 1.24+ no longer create those secrets.
+func serviceAccountToken(ctx context.Context, clientset kubernetes.Interface, namespace, name string) (string, error) {
+	serviceAccount, err := clientset.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get service account %s in namespace %s: %v", name, namespace, err)
+	}
+
+	for _, ref := range serviceAccount.Secrets {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			continue
+		}
+		if secret.Type == corev1.SecretTypeServiceAccountToken && len(secret.Data[corev1.ServiceAccountTokenKey]) > 0 {
+			return string(secret.Data[corev1.ServiceAccountTokenKey]), nil
+		}
+	}
+
+	tokenRequest, err := clientset.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, name, &authenticationv1.TokenRequest{}, metav1.CreateOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Kubernetes config: %v", err)
+		return "", fmt.Errorf("failed to request token for service account %s in namespace %s: %v", name, namespace, err)
 	}
+	if tokenRequest.Status.Token == "" {
+		return "", fmt.Errorf("no token issued for service account %s in namespace %s", name, namespace)
+	}
+	return tokenRequest.Status.Token, nil
+}
+
+// getKubernetesClient creates a Kubernetes client from the given REST config, giving up on any API request that
+// takes longer than timeout. A zero timeout means no limit.
+func getKubernetesClient(config *rest.Config, timeout time.Duration) (*kubernetes.Clientset, error) {
+	// The timeout only applies to this client; config is also used for the port-forward stream
+	config = rest.CopyConfig(config)
+	config.Timeout = timeout
 
 	// Create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
@@ -138,20 +370,37 @@ func getKubernetesClient() (*kubernetes.Clientset, error) {
 	return clientset, nil
 }
 
+// apiContext derives the context for Kubernetes API lookups, bounded by timeout unless it is zero
+func apiContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 // findFreePort finds an available local port to use for port-forwarding
-func findFreePort() (int, error) {
-	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
+func findFreePort() (int, net.Listener, error) {
+	return reserveLocalPort(0)
+}
+
+// reserveLocalPort listens on the local port, or on a free one when port is 0, returning the port and the listener
+func reserveLocalPort(port int) (int, net.Listener, error) {
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
+	// Keep listening so that nothing else can take the port before the port-forward binds it; the caller closes
+	// the listener right before that
 	l, err := net.ListenTCP("tcp", addr)
 	if err != nil {
-		return 0, err
+		if port != 0 {
+			return 0, nil, fmt.Errorf("local port %d is not available: %v", port, err)
+		}
+		return 0, nil, err
 	}
-	defer l.Close()
 
-	return l.Addr().(*net.TCPAddr).Port, nil
+	return l.Addr().(*net.TCPAddr).Port, l, nil
 }
 
 // Interface to abstract Kubernetes client operations for testing
@@ -161,164 +410,548 @@ type KubeClient interface {
 	GetStatefulSet(namespace, name string) (*appsv1.StatefulSet, error)
 	GetDaemonSet(namespace, name string) (*appsv1.DaemonSet, error)
 	GetReplicaSet(namespace, name string) (*appsv1.ReplicaSet, error)
+	GetJob(namespace, name string) (*batchv1.Job, error)
 	ListPods(namespace string, selector labels.Selector) (*corev1.PodList, error)
+	ListServices(namespace string) (*corev1.ServiceList, error)
+	GetPod(namespace, name string) (*corev1.Pod, error)
 }
 
 // Implementation of KubeClient using real Kubernetes client
 type RealKubeClient struct {
 	clientset kubernetes.Interface
+	// ctx bounds every API call; context.TODO() is used when it is nil
+	ctx context.Context
+}
+
+// requestContext returns the context to make API calls with
+func (r *RealKubeClient) requestContext() context.Context {
+	if r.ctx == nil {
+		return context.TODO()
+	}
+	return r.ctx
 }
 
 func (r *RealKubeClient) GetService(namespace, name string) (*corev1.Service, error) {
-	return r.clientset.CoreV1().Services(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return r.clientset.CoreV1().Services(namespace).Get(r.requestContext(), name, metav1.GetOptions{})
 }
 
 func (r *RealKubeClient) GetDeployment(namespace, name string) (*appsv1.Deployment, error) {
-	return r.clientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return r.clientset.AppsV1().Deployments(namespace).Get(r.requestContext(), name, metav1.GetOptions{})
 }
 
 func (r *RealKubeClient) GetStatefulSet(namespace, name string) (*appsv1.StatefulSet, error) {
-	return r.clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return r.clientset.AppsV1().StatefulSets(namespace).Get(r.requestContext(), name, metav1.GetOptions{})
 }
 
 func (r *RealKubeClient) GetDaemonSet(namespace, name string) (*appsv1.DaemonSet, error) {
-	return r.clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return r.clientset.AppsV1().DaemonSets(namespace).Get(r.requestContext(), name, metav1.GetOptions{})
 }
 
 func (r *RealKubeClient) GetReplicaSet(namespace, name string) (*appsv1.ReplicaSet, error) {
-	return r.clientset.AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	return r.clientset.AppsV1().ReplicaSets(namespace).Get(r.requestContext(), name, metav1.GetOptions{})
+}
+
+func (r *RealKubeClient) GetJob(namespace, name string) (*batchv1.Job, error) {
+	return r.clientset.BatchV1().Jobs(namespace).Get(r.requestContext(), name, metav1.GetOptions{})
 }
 
 func (r *RealKubeClient) ListPods(namespace string, selector labels.Selector) (*corev1.PodList, error) {
-	return r.clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+	return r.clientset.CoreV1().Pods(namespace).List(r.requestContext(), metav1.ListOptions{
 		LabelSelector: selector.String(),
 	})
 }
 
+func (r *RealKubeClient) ListServices(namespace string) (*corev1.ServiceList, error) {
+	return r.clientset.CoreV1().Services(namespace).List(r.requestContext(), metav1.ListOptions{})
+}
+
+func (r *RealKubeClient) GetPod(namespace, name string) (*corev1.Pod, error) {
+	return r.clientset.CoreV1().Pods(namespace).Get(r.requestContext(), name, metav1.GetOptions{})
+}
+
+// resolveOptions tunes how a resource is resolved to the pod we forward to
+type resolveOptions struct {
+	// podSelector, when set, is ANDed with the resource's own selector to narrow the candidate pods
+	podSelector labels.Selector
+
+	// selector, when set, picks a pod in the URL's namespace by its labels alone, ignoring the resource in the URL
+	selector string
+
+	// noResolve takes the name in the URL to be a pod and forwards to it without looking anything up, unless the
+	// URL names the port
+	noResolve bool
+
+	// allowNotReady lets a service or workload resolve to a pod that is not running and ready when there is no
+	// other, and forwards to a pod named in the URL whatever its phase; by default such pods are refused.
+	// --insecure-port-forward is an alias of --allow-not-ready.
+	allowNotReady bool
+
+	// verbose prints each resolution step to stderr
+	verbose bool
+}
+
+// logf prints a resolution step to stderr in verbose mode
+func (opts resolveOptions) logf(format string, args ...any) {
+	if opts.verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
 // ForwardTarget represents the target for port forwarding
 type ForwardTarget struct {
-	Name      string
+	Name string
+	// Namespace is empty when the service should be looked up across all namespaces
 	Namespace string
 	Kind      resourceType
 	Port      int
+	// PortName is the named port from the URL until it has been resolved to Port
+	PortName string
+}
+
+func (f *ForwardTarget) String() string {
+	return fmt.Sprintf("namespaces=%s, name=%s, type=%s, port=%d", f.Namespace, f.Name, string(f.Kind), f.Port)
+}
+
+// checkRunning reports whether a pod named in the URL is looked up to refuse it when it is not running;
+// --allow-not-ready skips the check, and with --no-resolve nothing is looked up at all
+func (opts resolveOptions) checkRunning() bool {
+	return !opts.allowNotReady && !opts.noResolve
+}
+
+// resolveTarget connects to the cluster and returns the pod to forward to for the resource. A pod named in the URL
+// is only looked up to resolve a named port and to check that it is running.
+func resolveTarget(ctx context.Context, res *ForwardTarget, kube kubeOptions, opts resolveOptions) (*ForwardTarget, error) {
+	if res.Kind == resourceTypePod && res.PortName == "" && opts.selector == "" && !opts.checkRunning() {
+		return res, nil
+	}
+
+	config, err := getRESTConfig(ctx, kube)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get REST config: %v", err)
+	}
+	clientset, err := getKubernetesClient(config, kube.apiTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes client: %v", err)
+	}
+
+	lookupCtx, cancel := apiContext(ctx, kube.apiTimeout)
+	defer cancel()
+	return resolveTargetWithClient(&RealKubeClient{clientset: clientset, ctx: lookupCtx}, res, opts)
+}
+
+// resolveTargetWithClient returns the pod to forward to for the resource with a client interface. Pods found by
+// listing are already known to be running unless --allow-not-ready let servingPod pick one that is not.
+func resolveTargetWithClient(client KubeClient, res *ForwardTarget, opts resolveOptions) (*ForwardTarget, error) {
+	if opts.selector != "" {
+		return findTargetBySelector(client, res.Namespace, opts.selector, res.Port, res.PortName, opts.allowNotReady)
+	}
+	if res.Kind != resourceTypePod {
+		return findTargetForServiceWithClient(client, res, opts)
+	}
+	if res.PortName == "" && !opts.checkRunning() {
+		return res, nil
+	}
+
+	// A pod named in the URL is fetched once for both its phase and its named port
+	pod, err := client.GetPod(res.Namespace, res.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s in namespace %s: %v", res.Name, res.Namespace, err)
+	}
+	if opts.checkRunning() {
+		if err := checkPodRunning(pod); err != nil {
+			return nil, err
+		}
+	}
+	return podTarget(pod, res.Port, res.PortName)
+}
+
+// checkPodRunning refuses to forward to a pod that is not running, like kubectl port-forward does
+func checkPodRunning(pod *corev1.Pod) error {
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("pod %s/%s is not running (phase %s); use --allow-not-ready to forward to it anyway",
+			pod.Namespace, pod.Name, pod.Status.Phase)
+	}
+	return nil
 }
 
-// findTargetForService finds a pod that matches the service's selector
-func findTargetForService(clientset *kubernetes.Clientset, res *ForwardTarget) (*ForwardTarget, error) {
-	// Create real client wrapper
-	realClient := &RealKubeClient{clientset: clientset}
-	return findTargetForServiceWithClient(realClient, res)
+// resolvePodPort looks up the target's named port, if any, on the pod
+func resolvePodPort(client KubeClient, target *ForwardTarget) (*ForwardTarget, error) {
+	if target.PortName == "" {
+		return target, nil
+	}
+	pod, err := client.GetPod(target.Namespace, target.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s in namespace %s: %v", target.Name, target.Namespace, err)
+	}
+	return podTarget(pod, target.Port, target.PortName)
+}
+
+// podTarget returns the target for forwarding to port on the pod, or to the container port called portName when set
+func podTarget(pod *corev1.Pod, port int, portName string) (*ForwardTarget, error) {
+	if portName != "" {
+		var err error
+		if port, err = containerPort(pod, portName); err != nil {
+			return nil, err
+		}
+	}
+	return &ForwardTarget{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Kind:      resourceTypePod,
+		Port:      port,
+	}, nil
+}
+
+// containerPort returns the number of the container port called name in the pod
+func containerPort(pod *corev1.Pod, name string) (int, error) {
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == name {
+				return int(port.ContainerPort), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("pod %s/%s has no container port named %s", pod.Namespace, pod.Name, name)
+}
+
+// servicePort resolves the service port called name to the port its pods listen on: a number, or the name of a
+// container port when the service's targetPort is a name
+func servicePort(service *corev1.Service, name string) (int, string, error) {
+	for _, port := range service.Spec.Ports {
+		if port.Name != name {
+			continue
+		}
+		switch {
+		case port.TargetPort.Type == intstr.String:
+			return 0, port.TargetPort.StrVal, nil
+		case port.TargetPort.IntVal != 0:
+			return int(port.TargetPort.IntVal), "", nil
+		default:
+			return int(port.Port), "", nil
+		}
+	}
+	return 0, "", fmt.Errorf("service %s/%s has no port named %s", service.Namespace, service.Name, name)
+}
+
+// findAllTargets connects to the cluster and returns a target for every pod behind the resource
+func findAllTargets(ctx context.Context, res *ForwardTarget, kube kubeOptions, opts resolveOptions) ([]*ForwardTarget, error) {
+	config, err := getRESTConfig(ctx, kube)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get REST config: %v", err)
+	}
+	clientset, err := getKubernetesClient(config, kube.apiTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes client: %v", err)
+	}
+
+	lookupCtx, cancel := apiContext(ctx, kube.apiTimeout)
+	defer cancel()
+	return findAllTargetsForServiceWithClient(&RealKubeClient{clientset: clientset, ctx: lookupCtx}, res, opts)
 }
 
 // findTargetForServiceWithClient finds a pod that matches the resource's selector with a client interface
-func findTargetForServiceWithClient(client KubeClient, res *ForwardTarget) (*ForwardTarget, error) {
+func findTargetForServiceWithClient(client KubeClient, res *ForwardTarget, opts resolveOptions) (*ForwardTarget, error) {
+	res, pods, err := findPodsForResource(client, res, opts)
+	if err != nil || pods == nil {
+		return res, err
+	}
+
+	// Use the best matching pod
+	pod, err := servingPod(pods, opts.allowNotReady)
+	if err != nil {
+		return nil, fmt.Errorf("no pod of %s %s in namespace %s is ready: %v", string(res.Kind), res.Name, res.Namespace, err)
+	}
+	fmt.Fprintf(os.Stderr, "Found matching pod: %s for %s: %s\n", pod.Name, string(res.Kind), res.Name)
+
+	// Return an updated target
+	return podTarget(pod, res.Port, res.PortName)
+}
+
+// findTargetBySelector finds a pod in namespace matching the label selector directly, without going through a
+// service or workload
+func findTargetBySelector(client KubeClient, namespace, selector string, port int, portName string, allowNotReady bool) (*ForwardTarget, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+	}
+
+	pods, err := client.ListPods(namespace, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods matching %s in namespace %s: %v", selector, namespace, err)
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no pods found in namespace %s matching selector %s", namespace, selector)
+	}
+
+	pod, err := servingPod(pods.Items, allowNotReady)
+	if err != nil {
+		return nil, fmt.Errorf("no pod in namespace %s matching selector %s is ready: %v", namespace, selector, err)
+	}
+	fmt.Fprintf(os.Stderr, "Found matching pod: %s for selector: %s\n", pod.Name, selector)
+
+	// An empty namespace searches all of them, so take the namespace from the pod
+	return podTarget(pod, port, portName)
+}
+
+// servingPod picks the pod to forward to from a non-empty list: the preferred one that is running, ready and not
+// being deleted. With allowNotReady it falls back to the preferred one of the others; otherwise it returns an error
+// listing why each pod was skipped.
+func servingPod(pods []corev1.Pod, allowNotReady bool) (*corev1.Pod, error) {
+	pod := preferredPod(pods)
+	if podRank(pod) == servingPodRank || allowNotReady {
+		return pod, nil
+	}
+
+	reasons := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		reasons = append(reasons, fmt.Sprintf("%s is %s", pod.Name, podState(&pod)))
+	}
+	return nil, fmt.Errorf("%s; use --allow-not-ready to forward to one anyway", strings.Join(reasons, ", "))
+}
+
+// podState describes why a pod is not ready to forward to
+func podState(pod *corev1.Pod) string {
+	switch {
+	case pod.DeletionTimestamp != nil:
+		return "terminating"
+	case pod.Status.Phase != corev1.PodRunning:
+		if pod.Status.Phase == "" {
+			return "not scheduled yet"
+		}
+		return strings.ToLower(string(pod.Status.Phase))
+	default:
+		return "running but not ready"
+	}
+}
+
+// preferredPod picks the pod most likely to answer from a non-empty list: the first one that is running, ready
+// and not being deleted, else the first running one that is not being deleted, else the first running one, else
+// the first one
+func preferredPod(pods []corev1.Pod) *corev1.Pod {
+	best, bestRank := &pods[0], podRank(&pods[0])
+	for i := range pods[1:] {
+		pod := &pods[i+1]
+		if rank := podRank(pod); rank > bestRank {
+			best, bestRank = pod, rank
+		}
+	}
+	return best
+}
+
+// podRank scores how suitable a pod is to forward to, up to servingPodRank for a pod that is running, ready and not
+// being deleted
+func podRank(pod *corev1.Pod) int {
+	switch {
+	case pod.Status.Phase != corev1.PodRunning:
+		return 0
+	case pod.DeletionTimestamp != nil:
+		return 1
+	case !isPodReady(pod):
+		return 2
+	default:
+		return servingPodRank
+	}
+}
+
+// servingPodRank is the podRank of a pod that is ready to be forwarded to
+const servingPodRank = 3
+
+// isPodReady reports whether the pod's Ready condition is true
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// findAllTargetsForServiceWithClient returns a target for every pod that matches the resource's selector
+func findAllTargetsForServiceWithClient(client KubeClient, res *ForwardTarget, opts resolveOptions) ([]*ForwardTarget, error) {
+	res, pods, err := findPodsForResource(client, res, opts)
+	if err != nil {
+		return nil, err
+	}
+	if pods == nil {
+		target, err := resolvePodPort(client, res)
+		if err != nil {
+			return nil, err
+		}
+		return []*ForwardTarget{target}, nil
+	}
+
+	targets := make([]*ForwardTarget, 0, len(pods))
+	for i := range pods {
+		target, err := podTarget(&pods[i], res.Port, res.PortName)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// findPodsForResource lists the pods that match the resource's selector. It returns the resource with its
+// namespace filled in, and nil pods if the resource already is a pod.
+func findPodsForResource(client KubeClient, res *ForwardTarget, opts resolveOptions) (*ForwardTarget, []corev1.Pod, error) {
 	var selector labels.Selector
 	var err error
 
+	// A service without a namespace is searched for across all namespaces
+	if res.Namespace == "" && res.Kind == resourceTypeSvc {
+		namespace, err := findServiceNamespace(client, res.Name)
+		if err != nil {
+			return res, nil, err
+		}
+		opts.logf("Found service %s in namespace %s", res.Name, namespace)
+		res = &ForwardTarget{
+			Name:      res.Name,
+			Namespace: namespace,
+			Kind:      res.Kind,
+			Port:      res.Port,
+			PortName:  res.PortName,
+		}
+	}
+
 	switch res.Kind {
 	case resourceTypeSvc:
 		// Get the service to find its selectors
 		service, err := client.GetService(res.Namespace, res.Name)
 		if err != nil {
-			return res, fmt.Errorf("failed to get service %s in namespace %s: %v", res.Name, res.Namespace, err)
+			return res, nil, fmt.Errorf("failed to get service %s in namespace %s: %v", res.Name, res.Namespace, err)
 		}
 		selector = labels.Set(service.Spec.Selector).AsSelector()
+
+		// A named port is one of the service's ports; what it maps to is what the pods listen on
+		if res.PortName != "" {
+			port, portName, err := servicePort(service, res.PortName)
+			if err != nil {
+				return res, nil, err
+			}
+			res = &ForwardTarget{Name: res.Name, Namespace: res.Namespace, Kind: res.Kind, Port: port, PortName: portName}
+		}
 	case resourceTypeDeployment:
 		// Get the deployment to find its selectors
 		deployment, err := client.GetDeployment(res.Namespace, res.Name)
 		if err != nil {
-			return res, fmt.Errorf("failed to get deployment %s in namespace %s: %v", res.Name, res.Namespace, err)
+			return res, nil, fmt.Errorf("failed to get deployment %s in namespace %s: %v", res.Name, res.Namespace, err)
 		}
 		selector, err = metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
 		if err != nil {
-			return res, fmt.Errorf("failed to convert deployment selector to labels selector: %v", err)
+			return res, nil, fmt.Errorf("failed to convert deployment selector to labels selector: %v", err)
 		}
 	case resourceTypeStatefulSet:
 		// Get the statefulset to find its selectors
 		statefulset, err := client.GetStatefulSet(res.Namespace, res.Name)
 		if err != nil {
-			return res, fmt.Errorf("failed to get statefulset %s in namespace %s: %v", res.Name, res.Namespace, err)
+			return res, nil, fmt.Errorf("failed to get statefulset %s in namespace %s: %v", res.Name, res.Namespace, err)
 		}
 		selector, err = metav1.LabelSelectorAsSelector(statefulset.Spec.Selector)
 		if err != nil {
-			return res, fmt.Errorf("failed to convert statefulset selector to labels selector: %v", err)
+			return res, nil, fmt.Errorf("failed to convert statefulset selector to labels selector: %v", err)
 		}
 	case resourceTypeDaemonSet:
 		// Get the daemonset to find its selectors
 		daemonset, err := client.GetDaemonSet(res.Namespace, res.Name)
 		if err != nil {
-			return res, fmt.Errorf("failed to get daemonset %s in namespace %s: %v", res.Name, res.Namespace, err)
+			return res, nil, fmt.Errorf("failed to get daemonset %s in namespace %s: %v", res.Name, res.Namespace, err)
 		}
 		selector, err = metav1.LabelSelectorAsSelector(daemonset.Spec.Selector)
 		if err != nil {
-			return res, fmt.Errorf("failed to convert daemonset selector to labels selector: %v", err)
+			return res, nil, fmt.Errorf("failed to convert daemonset selector to labels selector: %v", err)
 		}
 	case resourceTypeReplicaSet:
 		// Get the replicaset to find its selectors
 		replicaset, err := client.GetReplicaSet(res.Namespace, res.Name)
 		if err != nil {
-			return res, fmt.Errorf("failed to get replicaset %s in namespace %s: %v", res.Name, res.Namespace, err)
+			return res, nil, fmt.Errorf("failed to get replicaset %s in namespace %s: %v", res.Name, res.Namespace, err)
 		}
 		selector, err = metav1.LabelSelectorAsSelector(replicaset.Spec.Selector)
 		if err != nil {
-			return res, fmt.Errorf("failed to convert replicaset selector to labels selector: %v", err)
+			return res, nil, fmt.Errorf("failed to convert replicaset selector to labels selector: %v", err)
+		}
+	case resourceTypeJob:
+		// Make sure the job exists, then find its pods by the job-name label the job controller sets on them
+		if _, err := client.GetJob(res.Namespace, res.Name); err != nil {
+			return res, nil, fmt.Errorf("failed to get job %s in namespace %s: %v", res.Name, res.Namespace, err)
 		}
+		selector = labels.Set{batchv1.JobNameLabel: res.Name}.AsSelector()
 	default:
 		// For pods, no need to look up selectors
-		return res, nil
+		return res, nil, nil
+	}
+
+	// Narrow the resource's selector further if an additional pod selector was given
+	if opts.podSelector != nil {
+		requirements, _ := opts.podSelector.Requirements()
+		selector = selector.Add(requirements...)
 	}
 
 	// Get pods matching the resource's selector
+	opts.logf("Looking up pods for %s %s in namespace %s with selector %s", string(res.Kind), res.Name, res.Namespace, selector)
 	pods, err := client.ListPods(res.Namespace, selector)
 	if err != nil {
-		return res, fmt.Errorf("failed to list pods for %s %s: %v", string(res.Kind), res.Name, err)
+		return res, nil, fmt.Errorf("failed to list pods for %s %s: %v", string(res.Kind), res.Name, err)
+	}
+	for _, pod := range pods.Items {
+		opts.logf("Candidate pod: %s (%s)", pod.Name, pod.Status.Phase)
 	}
 
 	if len(pods.Items) == 0 {
-		return res, fmt.Errorf("no pods found for %s %s in namespace %s", string(res.Kind), res.Name, res.Namespace)
+		if opts.podSelector != nil {
+			return res, nil, fmt.Errorf("no pods found for %s %s in namespace %s matching selector %s", string(res.Kind), res.Name, res.Namespace, selector)
+		}
+		return res, nil, fmt.Errorf("no pods found for %s %s in namespace %s", string(res.Kind), res.Name, res.Namespace)
 	}
 
-	// Use the first matching pod
-	targetName := pods.Items[0].GetName()
-	fmt.Printf("Found matching pod: %s for %s: %s\n", targetName, string(res.Kind), res.Name)
-
-	// Return an updated target
-	updatedTarget := &ForwardTarget{
-		Name:      targetName,
-		Namespace: res.Namespace,
-		Kind:      resourceTypePod,
-		Port:      res.Port,
-	}
-	return updatedTarget, nil
+	return res, pods.Items, nil
 }
 
-// runPortForward starts a port-forward using the Kubernetes client
-func runPortForward(res *ForwardTarget, localPort int, stopCh <-chan struct{}, readyCh chan struct{}) error {
-	// Get the Kubernetes client
-	clientset, err := getKubernetesClient()
+// findServiceNamespace returns the namespace of the only service called name across all namespaces
+func findServiceNamespace(client KubeClient, name string) (string, error) {
+	services, err := client.ListServices(metav1.NamespaceAll)
 	if err != nil {
-		return fmt.Errorf("failed to get Kubernetes client: %v", err)
+		return "", fmt.Errorf("failed to list services across all namespaces: %v", err)
 	}
 
-	// If the resource is a service, find a pod that matches the service's selector
-	target := res
-
-	if res.Kind != resourceTypePod {
-		updatedTarget, err := findTargetForService(clientset, res)
-		if err != nil {
-			return err
+	var namespaces []string
+	for _, service := range services.Items {
+		if service.Name == name {
+			namespaces = append(namespaces, service.Namespace)
 		}
-		target = updatedTarget
+	}
+
+	switch len(namespaces) {
+	case 0:
+		return "", fmt.Errorf("no service %s found in any namespace", name)
+	case 1:
+		return namespaces[0], nil
+	default:
+		sort.Strings(namespaces)
+		return "", fmt.Errorf("service %s exists in multiple namespaces: %s; specify one in the URL, e.g. http://%s.%s.svc",
+			name, strings.Join(namespaces, ", "), name, namespaces[0])
+	}
+}
+
+// runPortForward starts a port-forward to the pod using the Kubernetes client; other resources have to be
+// resolved to a pod with resolveTarget first.
+// An empty localAddress listens on localhost. The port-forward stops when stopCh is closed or ctx is done.
+func runPortForward(ctx context.Context, target *ForwardTarget, localAddress string, localPort int, kube kubeOptions, stopCh <-chan struct{}, readyCh chan struct{}) error {
+	if target.Kind != resourceTypePod {
+		return fmt.Errorf("cannot port-forward to %s %s: resolve it to a pod first", target.Kind, target.Name)
+	}
+	if target.PortName != "" {
+		return fmt.Errorf("cannot port-forward to port %s of pod %s: resolve it to a number first", target.PortName, target.Name)
 	}
 
 	// Get the REST config for the cluster
-	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		clientcmd.NewDefaultClientConfigLoadingRules(),
-		&clientcmd.ConfigOverrides{},
-	).ClientConfig()
+	config, err := getRESTConfig(ctx, kube)
 	if err != nil {
 		return fmt.Errorf("failed to get REST config: %v", err)
 	}
+
+	// Port-forward goes through a REST client for the core API group
+	restConfig := rest.CopyConfig(config)
 	restConfig.GroupVersion = &corev1.SchemeGroupVersion
 	restConfig.APIPath = "/api"
 	restConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
@@ -336,17 +969,146 @@ func runPortForward(res *ForwardTarget, localPort int, stopCh <-chan struct{}, r
 	url := restClient.Post().Resource(string(target.Kind)).Namespace(target.Namespace).Name(target.Name).SubResource("portforward").URL()
 
 	// Create the dialer for the port-forward using SPDY
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+	var dialer httpstream.Dialer = spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+	if kube.debug {
+		dialer = &debugDialer{Dialer: dialer}
+	}
+	if kube.traffic != nil {
+		dialer = &countingDialer{Dialer: dialer, traffic: kube.traffic}
+	}
 
 	// Prepare the ports to forward
 	ports := []string{fmt.Sprintf("%d:%d", localPort, target.Port)}
+	if localAddress == "" {
+		localAddress = "localhost"
+	}
+	return forwardWithRetry(ctx, dialer, localAddress, ports, kube.portForwardRetries, stopCh, readyCh)
+}
+
+// forwardWithRetry runs the port-forward over dialer, retrying up to retries times with a doubling wait when it
+// fails before it is ready. A port-forward that fails once it is ready is not retried, since the connections
+// going through it are already lost.
+func forwardWithRetry(ctx context.Context, dialer httpstream.Dialer, localAddress string, ports []string, retries int, stopCh <-chan struct{}, readyCh chan struct{}) error {
+	closeReady := sync.OnceFunc(func() { close(readyCh) })
+	delay := portForwardRetryDelay
+	for attempt := 0; ; attempt++ {
+		// Each attempt gets its own ready channel, relayed to readyCh, which can only be closed once
+		attemptReadyCh := make(chan struct{})
+		attemptDone := make(chan struct{})
+		go func() {
+			select {
+			case <-attemptReadyCh:
+				closeReady()
+			case <-attemptDone:
+			}
+		}()
 
-	// Create the port-forwarder
-	fw, err := portforward.New(dialer, ports, stopCh, readyCh, os.Stdout, os.Stderr)
+		err := forwardPorts(ctx, dialer, localAddress, ports, stopCh, attemptReadyCh)
+		close(attemptDone)
+		select {
+		case <-attemptReadyCh:
+			closeReady()
+			return err
+		default:
+		}
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Warning: port-forward failed: %v. Retrying in %v, %d retries left.\n", err, delay, retries-attempt)
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return err
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// forwardPorts runs one port-forward over dialer until stopCh is closed, the context is done or it fails
+func forwardPorts(ctx context.Context, dialer httpstream.Dialer, localAddress string, ports []string, stopCh <-chan struct{}, readyCh chan struct{}) error {
+	// The port-forwarder's stop channel is closed once it ends, so every attempt needs a new one
+	forwardStopCh := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stopCh:
+		case <-ctx.Done():
+		case <-done:
+		}
+		close(forwardStopCh)
+	}()
+
+	fw, err := portforward.NewOnAddresses(dialer, []string{localAddress}, ports, forwardStopCh, readyCh, os.Stdout, os.Stderr)
 	if err != nil {
 		return fmt.Errorf("failed to create port-forwarder: %v", err)
 	}
-
-	// Run the port-forward
 	return fw.ForwardPorts()
 }
+
+// PortForwardSession runs a port-forward in the background and lets its owner wait for it to be ready, stop it
+// and collect its result
+type PortForwardSession struct {
+	localPort int
+
+	// forward runs the port-forward until stopCh is closed, closing readyCh once it listens on the local port
+	forward func(stopCh <-chan struct{}, readyCh chan struct{}) error
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	doneCh   chan struct{}
+	err      error
+}
+
+// NewPortForwardSession returns a session that runs forward on localPort once started
+func NewPortForwardSession(localPort int, forward func(stopCh <-chan struct{}, readyCh chan struct{}) error) *PortForwardSession {
+	return &PortForwardSession{
+		localPort: localPort,
+		forward:   forward,
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+}
+
+// Start starts the port-forward and returns once it is ready, it failed, or ctx is done
+func (s *PortForwardSession) Start(ctx context.Context) error {
+	readyCh := make(chan struct{})
+	go func() {
+		s.err = s.forward(s.stopCh, readyCh)
+		close(s.doneCh)
+	}()
+
+	select {
+	case <-readyCh:
+		return nil
+	case <-s.doneCh:
+		if s.err == nil {
+			return fmt.Errorf("port-forward on local port %d ended before it was ready", s.localPort)
+		}
+		return s.err
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
+
+// LocalPort returns the local port the session forwards from
+func (s *PortForwardSession) LocalPort() int {
+	return s.localPort
+}
+
+// Stop terminates the port-forward; it is safe to call more than once
+func (s *PortForwardSession) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+// Wait blocks until the port-forward has ended and returns its error, if any
+func (s *PortForwardSession) Wait() error {
+	<-s.doneCh
+	return s.err
+}
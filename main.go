@@ -1,12 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
 func main() {
@@ -21,61 +34,244 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Identify the URL (last argument that looks like a URL)
-	serviceURL := ""
+	// kurl list shows the port-forwards started with --forward-only --label that are still running
+	if args[0] == "list" {
+		sessions, err := listSessions()
+		if err == nil {
+			err = printSessions(os.Stdout, sessions, time.Now())
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// kurl close <label> stops a port-forward started with --forward-only --label
+	if args[0] == "close" {
+		if len(args) != 2 {
+			fmt.Println("Usage: kurl close <label>")
+			os.Exit(1)
+		}
+		if err := closeSession(args[1]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Pull out the flags kurl handles itself so they are not mistaken for curl options
+	opts, args, err := extractKurlFlags(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Read -K/--config files into the arguments so both curl and the built-in client see their options
+	args, err = expandCurlConfig(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Like curl, -k skips verification altogether, so a --cacert given with it has no effect
+	if extractCACert(args) != "" && containsFlag(args, "-k", "--insecure") {
+		fmt.Fprintln(os.Stderr, "Warning: --insecure skips certificate verification, ignoring --cacert")
+	}
+
+	// An explicit --url takes precedence over guessing which argument is the URL
+	serviceURL, args := extractURL(args)
+	if inferred, ok := inferURLScheme(serviceURL); ok {
+		fmt.Fprintf(os.Stderr, "Warning: no scheme given in %s, assuming %s\n", serviceURL, inferred)
+		serviceURL = inferred
+	}
+
+	// Otherwise identify the URL (last argument that looks like a URL)
 	urlIndex := -1
 
 	// Look for the URL from the end of the arguments
-	for i := len(args) - 1; i >= 0; i-- {
+	for i := len(args) - 1; i >= 0 && serviceURL == ""; i-- {
 		arg := args[i]
 		if isURL(arg) {
 			serviceURL = arg
 			urlIndex = i
-			break
 		}
 	}
 
+	// Fall back to an argument that looks like a Kubernetes host given without a scheme
 	if serviceURL == "" {
+		for i := len(args) - 1; i >= 0; i-- {
+			if inferred, ok := inferURLScheme(args[i]); ok {
+				fmt.Fprintf(os.Stderr, "Warning: no scheme given in %s, assuming %s\n", args[i], inferred)
+				serviceURL = inferred
+				urlIndex = i
+				break
+			}
+		}
+	}
+
+	if serviceURL != "" && opts.stdin {
+		fmt.Println("Error: --stdin reads the URLs from stdin; do not give one in the arguments")
+		os.Exit(1)
+	}
+	if serviceURL == "" && !opts.stdin {
 		fmt.Println("Error: No Kubernetes service URL found in arguments")
 		fmt.Println("URLs should follow the format: http://service.namespace.svc:port")
 		os.Exit(1)
 	}
 
-	// Parse the URL and extract service information
-	res, err := parseKubernetesServiceURL(serviceURL)
-	if err != nil {
-		fmt.Printf("Error parsing service URL: %v\n", err)
-		os.Exit(1)
+	// Parse the URL and extract service information; with --stdin each URL is parsed as it is read
+	var res *forwardTarget
+	if !opts.stdin {
+		if res, err = parseTarget(serviceURL, opts); err != nil {
+			fmt.Printf("Error parsing service URL: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	// Find a free local port
-	localPort, err := findFreePort()
+	// Find a free local port, or take the one given with --local-port
+	localPort, reserved, err := reserveLocalPort(opts.localPort)
 	if err != nil {
 		fmt.Printf("Error finding free port: %v\n", err)
 		os.Exit(1)
 	}
 
+	// -m/--max-time bounds the whole operation, including the Kubernetes API calls and the port-forward
+	ctx := context.Background()
+	if timeout := extractTimeout(args); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Everything but the URL is passed on; options may also follow the URL
+	curlArgs := args
+	if urlIndex >= 0 {
+		curlArgs = append(args[:urlIndex:urlIndex], args[urlIndex+1:]...)
+	}
+
 	// Check if curl is available
 	curlAvailable := isCurlAvailable()
 
+	// --grpc needs HTTP/2, which curl only speaks when it was built with it; the built-in client always does
+	if opts.grpc {
+		curlArgs = append(grpcArgs(curlArgs, serviceURL), curlArgs...)
+		curlAvailable = curlAvailable && curlSupportsHTTP2()
+	}
+
+	// --data-json is shorthand for -d with the JSON headers
+	if opts.dataJSON != "" {
+		curlArgs = append(dataJSONArgs(curlArgs, opts.dataJSON), curlArgs...)
+	}
+
+	// --post-data is shorthand for -X POST -d, so that even an empty body is POSTed
+	if opts.postData != nil {
+		curlArgs = append(postDataArgs(*opts.postData), curlArgs...)
+	}
+
 	// Determine if verbose mode is enabled by checking if -v or --verbose is in the args
 	verbose := containsFlag(args, "-v", "--verbose")
+	opts.resolve.verbose = verbose || opts.kube.debug
 
-	if curlAvailable {
+	// --request-id tags the request with a fresh ID, unless the header is already set
+	if opts.requestID {
+		header := opts.requestIDHeader
+		if header == "" {
+			header = defaultRequestIDHeader
+		}
+		id, err := newRequestID()
+		if err != nil {
+			fmt.Printf("Error generating request ID: %v\n", err)
+			os.Exit(1)
+		}
+		if headerArgs := defaultHeaderArgs(curlArgs, header+": "+id); headerArgs != nil {
+			curlArgs = append(headerArgs, curlArgs...)
+			if verbose {
+				fmt.Fprintf(os.Stderr, "Request ID: %s\n", id)
+			}
+		}
+	}
+
+	// Check the user's preconditions before touching the pod
+	if opts.beforeForward != "" {
+		if err := runHook(ctx, opts.beforeForward); err != nil {
+			exitOnTimeout(ctx)
+			fmt.Printf("Error running --before-forward command: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+	}
+
+	if opts.stdin {
+		// Send a request to every URL read from stdin
+		runStdin(ctx, os.Stdin, localPort, reserved, curlArgs, verbose, opts)
+	} else if opts.allPods {
+		// Send the request to, or forward to, every pod behind the resource
+		runAllPods(ctx, res, localPort, reserved, serviceURL, curlArgs, verbose, curlAvailable, opts)
+	} else if opts.forwardOnly {
+		// Keep the port-forward open for the user's own client
+		runForwardOnly(ctx, res, localPort, reserved, serviceURL, opts)
+	} else if opts.exec != "" {
+		// Run the user's command against the port-forward instead of making a request
+		runWithExec(ctx, res, localPort, reserved, serviceURL, opts)
+	} else if opts.websocket {
+		// Talk to the WebSocket from the terminal instead of making a request
+		runWithWebSocket(ctx, res, localPort, reserved, serviceURL, curlArgs, opts)
+	} else if curlAvailable && !opts.needsBuiltinClient() {
 		// Use system curl with port-forward
-		runWithSystemCurlNew(res, localPort, serviceURL, args[:urlIndex], verbose)
+		runWithSystemCurlNew(ctx, res, localPort, reserved, serviceURL, curlArgs, verbose, opts)
 	} else {
 		// Fall back to current implementation
-		runWithCustomHTTPNew(res, localPort, serviceURL, args[:urlIndex], verbose)
+		runWithCustomHTTPNew(ctx, res, localPort, reserved, serviceURL, curlArgs, verbose, opts)
 	}
 }
 
+// parseTarget parses the service URL into the resource to forward to, as changed by --no-resolve and
+// --namespace-all
+func parseTarget(serviceURL string, opts *kurlOptions) (*forwardTarget, error) {
+	res, err := parseKubernetesServiceURL(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// A pod URL names the pod itself, so there are no pods for --pod-label-selector to narrow down
+	if res.kind == resourceTypePod && opts.resolve.podSelector != nil {
+		return nil, fmt.Errorf("--pod-label-selector cannot be used with a pod URL")
+	}
+
+	// With --no-resolve the name in the URL is taken to be a pod, so no Kubernetes lookups are needed
+	if opts.resolve.noResolve {
+		res.kind = resourceTypePod
+	}
+
+	// With --namespace-all, a service given without a namespace is looked up across all of them
+	if opts.namespaceAll && res.implicitNamespace {
+		res.namespace = ""
+	}
+	return res, nil
+}
+
 // isURL checks if a string looks like a URL
 func isURL(s string) bool {
-	// Simple check for URLs starting with http:// or https://
-	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+	// Simple check for URLs starting with http:// or https://; like the scheme itself, the check is case-insensitive
+	lower := strings.ToLower(s)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
 }
 
+// inferURLScheme turns a Kubernetes host given without a scheme, like name.namespace.svc:8080/path, into an http URL
+func inferURLScheme(s string) (string, bool) {
+	match := schemelessURLRegex.FindStringSubmatch(s)
+	if match == nil {
+		return "", false
+	}
+	if _, ok := resourceTypeAliases[match[3]]; !ok {
+		return "", false
+	}
+	return "http://" + s, true
+}
+
+// schemelessURLRegex matches <name>.<namespace>.<type>[:<port>][/path], capturing the type
+var schemelessURLRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?\.[a-z0-9]([-a-z0-9]*[a-z0-9])?\.([a-z]+)(:[0-9a-z-]+)?([/?#].*)?$`)
+
 // containsFlag checks if any of the provided arguments contains any of the specified flags
 func containsFlag(args []string, shortFlag string, longFlag string) bool {
 	for _, arg := range args {
@@ -86,41 +282,183 @@ func containsFlag(args []string, shortFlag string, longFlag string) bool {
 		if strings.HasPrefix(arg, shortFlag+"=") || strings.HasPrefix(arg, longFlag+"=") {
 			return true
 		}
+		// Check for short flags combined into one argument, like -vsi
+		if len(shortFlag) == 2 && len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			for _, c := range arg[1:] {
+				if c == rune(shortFlag[1]) {
+					return true
+				}
+				// The rest of the argument is the value of this flag, like -XPOST
+				if strings.ContainsRune(curlValueFlags, c) {
+					break
+				}
+			}
+		}
 	}
 	return false
 }
 
+// curlValueFlags lists curl's single-letter options that take a value
+const curlValueFlags = "AbcCdDeEFHKmoPQrtTuUwxXyYz"
+
 // isCurlAvailable checks if curl is installed on the system
 func isCurlAvailable() bool {
 	_, err := exec.LookPath("curl")
 	return err == nil
 }
 
-// runWithSystemCurlNew executes the port forward and uses system curl with the original args
-func runWithSystemCurlNew(res *forwardTarget, localPort int, serviceURL string, originalArgs []string, verbose bool) {
-	// Convert resource to ForwardTarget for port forwarding
-	forwardTarget := &ForwardTarget{
+// curlSupportsHTTP2 checks if the installed curl was built with HTTP/2 support
+func curlSupportsHTTP2() bool {
+	out, err := exec.Command("curl", "--version").Output()
+	return err == nil && strings.Contains(string(out), "HTTP2")
+}
+
+// grpcArgs returns the curl arguments --grpc adds to args: the gRPC-JSON transcoding headers the user did not set
+// themselves, and HTTP/2 with prior knowledge for plain http URLs since gRPC servers do not upgrade
+func grpcArgs(args []string, serviceURL string) []string {
+	grpc := defaultHeaderArgs(args, "Content-Type: application/grpc+json", "TE: trailers")
+	if strings.HasPrefix(strings.ToLower(serviceURL), "https://") {
+		return append(grpc, "--http2")
+	}
+	return append(grpc, "--http2-prior-knowledge")
+}
+
+// dataJSONArgs returns the curl arguments --data-json stands for: the JSON as the body, sent as is like
+// --data-binary, and the JSON Content-Type and Accept headers the user did not set themselves
+func dataJSONArgs(args []string, data string) []string {
+	headers := defaultHeaderArgs(args, "Content-Type: application/json", "Accept: application/json")
+	return append(headers, "--data-binary", data)
+}
+
+// postDataArgs returns the curl arguments --post-data stands for: the body as -d and the POST method, which a -X
+// given by the user still overrides
+func postDataArgs(data string) []string {
+	return []string{"-X", "POST", "--data", data}
+}
+
+// defaultRequestIDHeader is the header --request-id sets without --request-id-header
+const defaultRequestIDHeader = "X-Request-Id"
+
+// newRequestID returns a random version 4 UUID
+func newRequestID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// defaultHeaderArgs returns -H arguments for those of headers whose name is not already set by args
+func defaultHeaderArgs(args []string, headers ...string) []string {
+	var headerArgs []string
+	for _, header := range headers {
+		name, _, _ := strings.Cut(header, ":")
+		if !slices.ContainsFunc(extractHeaders(args), func(h string) bool {
+			return strings.EqualFold(strings.TrimSpace(strings.SplitN(h, ":", 2)[0]), name)
+		}) {
+			headerArgs = append(headerArgs, "-H", header)
+		}
+	}
+	return headerArgs
+}
+
+// exitOnTimeout exits with curl's "operation timed out" code once the --max-time deadline has passed
+func exitOnTimeout(ctx context.Context) {
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Println("Error: operation timed out")
+		os.Exit(28)
+	}
+}
+
+// exitCode returns the exit code kurl ends with after a failed request: curl's own code, the one given for the
+// response status by --response-code-to-exit, or 22 for an error response with --fail like curl would and for a
+// failed --assert-* check
+func exitCode(err error) int {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.ExitCode != 0 {
+			return httpErr.ExitCode
+		}
+		return 22
+	}
+	var assertErr *AssertionError
+	if errors.As(err, &assertErr) {
+		return 22
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+// reportTraffic prints how many bytes went through the port-forwards for --trace-port-forward
+func reportTraffic(opts *kurlOptions) {
+	if opts.kube.traffic != nil {
+		fmt.Fprintln(os.Stderr, opts.kube.traffic)
+	}
+}
+
+// toForwardTarget converts the resource parsed from the URL to a ForwardTarget for port forwarding
+func toForwardTarget(res *forwardTarget) *ForwardTarget {
+	return &ForwardTarget{
 		Name:      res.name,
 		Namespace: res.namespace,
 		Kind:      res.kind,
 		Port:      res.port,
+		PortName:  res.portName,
+	}
+}
+
+// startPortForward resolves the target to a pod and starts forwarding localAddress:localPort to it in the
+// background, returning the pod and the session once the port-forward is ready. An empty localAddress listens on
+// localhost. reserved, if not nil, is the listener holding localPort; it is closed just before the port-forward
+// binds the port. Stopping the session terminates the port-forward.
+func startPortForward(ctx context.Context, target *ForwardTarget, localAddress string, localPort int, reserved net.Listener, opts *kurlOptions) (*ForwardTarget, *PortForwardSession) {
+	// Find the pod up front so callers know which one they are talking to
+	pod, err := resolveTarget(ctx, target, opts.kube, opts.resolve)
+	if err != nil {
+		exitOnTimeout(ctx)
+		fmt.Printf("Error in port-forward: %v\n", err)
+		os.Exit(1)
+	}
+
+	// --all-pods already labels each response with its pod
+	if opts.showPod && !opts.allPods {
+		fmt.Fprintf(os.Stderr, "# pod: %s/%s\n", pod.Namespace, pod.Name)
 	}
 
-	// Create channels for port-forward control
-	stopCh := make(chan struct{}, 1)
-	readyCh := make(chan struct{}, 1)
+	// Start port-forward and wait for it to be ready
+	session := NewPortForwardSession(localPort, func(stopCh <-chan struct{}, readyCh chan struct{}) error {
+		if reserved != nil {
+			reserved.Close()
+		}
+		return runPortForward(ctx, pod, localAddress, localPort, opts.kube, stopCh, readyCh)
+	})
+	if err := session.Start(ctx); err != nil {
+		exitOnTimeout(ctx)
+		fmt.Printf("Error in port-forward: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Start port-forward in a goroutine
+	// A port-forward that breaks while in use cannot be recovered
 	go func() {
-		err := runPortForward(forwardTarget, localPort, stopCh, readyCh)
-		if err != nil {
+		if err := session.Wait(); err != nil {
+			exitOnTimeout(ctx)
 			fmt.Printf("Error in port-forward: %v\n", err)
 			os.Exit(1)
 		}
 	}()
 
-	// Wait for port-forward to be ready
-	<-readyCh
+	return pod, session
+}
+
+// runWithSystemCurlNew executes the port forward and uses system curl with the original args
+func runWithSystemCurlNew(ctx context.Context, res *forwardTarget, localPort int, reserved net.Listener, serviceURL string, originalArgs []string, verbose bool, opts *kurlOptions) {
+	// Start port-forward and wait for it to be ready
+	_, session := startPortForward(ctx, toForwardTarget(res), "", localPort, reserved, opts)
 
 	// If verbose flag is passed, print which pod we are going to port forward and which local port
 	if verbose {
@@ -130,138 +468,518 @@ func runWithSystemCurlNew(res *forwardTarget, localPort int, serviceURL string,
 	// Construct the local URL for the HTTP request
 	localURL := reconstructURL(serviceURL, localPort)
 
-	// Build the curl command using the original args with the new local URL
-	curlCmd := buildCurlCommandFromArgs(originalArgs, localURL)
+	err := runCurl(ctx, originalArgs, localURL, verbose)
+	reportTraffic(opts)
+	if err != nil {
+		exitOnTimeout(ctx)
+		fmt.Printf("Error executing curl command: %v\n", err)
+		session.Stop()
+		os.Exit(exitCode(err))
+	}
+
+	// Stop the session to terminate port-forward
+	session.Stop()
+}
+
+// runCurl executes the system curl with the original args against the local URL
+func runCurl(ctx context.Context, originalArgs []string, localURL string, verbose bool) error {
+	// Build the curl arguments using the original args with the new local URL
+	args := buildCurlArgs(originalArgs, localURL)
 
-	// If verbose flag is passed, print the actual raw curl command we invoked
+	// If verbose flag is passed, print the curl command we invoked, quoted so it can be pasted into a shell
 	if verbose {
-		fmt.Printf("Executing curl command: %s\n", curlCmd)
+		fmt.Printf("Executing curl command: %s\n", shellCommand("curl", args))
 	}
 
-	// Execute the curl command
-	cmd := exec.Command("sh", "-c", curlCmd)
+	// Run curl directly; without a shell in between the arguments reach it exactly as given
+	cmd := exec.CommandContext(ctx, "curl", args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 
-	err := cmd.Run()
+	return cmd.Run()
+}
+
+// runWithCustomHTTPNew executes the port forward and uses custom HTTP client with selected args only
+func runWithCustomHTTPNew(ctx context.Context, res *forwardTarget, localPort int, reserved net.Listener, serviceURL string, originalArgs []string, verbose bool, opts *kurlOptions) {
+	// Start port-forward and wait for it to be ready
+	pod, session := startPortForward(ctx, toForwardTarget(res), "", localPort, reserved, opts)
+	fmt.Fprintf(os.Stderr, "Port-forward established. Forwarding to localhost:%d\n", localPort)
+
+	// Construct the local URL for the HTTP request
+	localURL := reconstructURL(serviceURL, localPort)
+
+	// --repeat sends the same request again until one fails
+	start := time.Now()
+	var err error
+	for i := 1; i <= max(opts.repeat, 1) && err == nil; i++ {
+		if _, err = runCustomHTTP(ctx, originalArgs, localURL, verbose, pod, serviceURL, i, opts); err == nil {
+			endResponse(opts)
+		}
+	}
+	if opts.repeat > 1 && err == nil {
+		elapsed := time.Since(start)
+		fmt.Fprintf(os.Stderr, "%d requests in %v (%.1f requests/s)\n", opts.repeat, elapsed.Round(time.Millisecond), float64(opts.repeat)/elapsed.Seconds())
+	}
+	reportTraffic(opts)
 	if err != nil {
-		fmt.Printf("Error executing curl command: %v\n", err)
-		close(stopCh)
-		os.Exit(1)
+		exitOnTimeout(ctx)
+		reportRequestError(os.Stderr, err)
+		session.Stop()
+		os.Exit(exitCode(err))
 	}
 
-	// Close the stop channel to terminate port-forward
-	close(stopCh)
+	// Stop the session to terminate port-forward
+	session.Stop()
 }
 
-// runWithCustomHTTPNew executes the port forward and uses custom HTTP client with selected args only
-func runWithCustomHTTPNew(res *forwardTarget, localPort int, serviceURL string, originalArgs []string, verbose bool) {
-	// Convert resource to ForwardTarget for port forwarding
-	forwardTarget := &ForwardTarget{
-		Name:      res.name,
-		Namespace: res.namespace,
-		Kind:      res.kind,
-		Port:      res.port,
+// reportRequestError prints why the request failed to w, stderr in kurl so that it does not mix with the response
+// on stdout. A status mapped by --response-code-to-exit is not a failure: it only sets the exit code.
+func reportRequestError(w io.Writer, err error) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.ExitCode != 0 {
+		return
 	}
+	var assertErr *AssertionError
+	if errors.As(err, &assertErr) {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "Error making HTTP request: %v\n", err)
+}
 
-	// Create channels for port-forward control
-	stopCh := make(chan struct{}, 1)
-	readyCh := make(chan struct{}, 1)
-
-	// Start port-forward in a goroutine
-	go func() {
-		err := runPortForward(forwardTarget, localPort, stopCh, readyCh)
-		if err != nil {
-			fmt.Printf("Error in port-forward: %v\n", err)
-			os.Exit(1)
-		}
-	}()
+// runWithWebSocket executes the port forward and connects stdin and stdout to a WebSocket opened through it
+func runWithWebSocket(ctx context.Context, res *forwardTarget, localPort int, reserved net.Listener, serviceURL string, originalArgs []string, opts *kurlOptions) {
+	// Start port-forward and wait for it to be ready
+	_, session := startPortForward(ctx, toForwardTarget(res), "", localPort, reserved, opts)
+	fmt.Fprintf(os.Stderr, "Port-forward established. Forwarding to localhost:%d\n", localPort)
 
-	// Wait for port-forward to be ready
-	<-readyCh
-	fmt.Printf("Port-forward established. Forwarding to localhost:%d\n", localPort)
+	err := makeWebSocketConnection(ctx, reconstructURL(serviceURL, localPort), websocketOptions{
+		headers:  extractHeaders(originalArgs),
+		insecure: containsFlag(originalArgs, "-k", "--insecure"),
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+	})
+	reportTraffic(opts)
+	if err != nil {
+		exitOnTimeout(ctx)
+		fmt.Printf("Error in WebSocket connection: %v\n", err)
+		session.Stop()
+		os.Exit(1)
+	}
 
-	// Construct the local URL for the HTTP request
-	localURL := reconstructURL(serviceURL, localPort)
+	// Stop the session to terminate port-forward
+	session.Stop()
+}
 
+// runCustomHTTP sends the request for serviceURL to localURL with the built-in client, using the args it
+// understands, then runs the --after-request hook. pod is the pod behind the port-forward, and index the position of
+// the request among those of a --stdin, --all-pods or --repeat run, starting at 1, for --output-template. It returns
+// what is known about the response, which has a zero status code when none was received.
+func runCustomHTTP(ctx context.Context, originalArgs []string, localURL string, verbose bool, pod *ForwardTarget, serviceURL string, index int, opts *kurlOptions) (responseStats, error) {
 	// Extract flags that affect HTTP request from original arguments for fallback HTTP client
 	method := extractMethod(originalArgs)
 	headers := extractHeaders(originalArgs)
 	data, dataAscii, dataBinary := extractData(originalArgs)
+	if opts.base64Decode {
+		for _, body := range []*string{&data, &dataAscii, &dataBinary} {
+			decoded, err := decodeBase64(*body)
+			if err != nil {
+				return responseStats{}, fmt.Errorf("invalid base64 request body: %v", err)
+			}
+			*body = decoded
+		}
+	}
 	form := extractForm(originalArgs)
+	uploadFile := extractUploadFile(originalArgs)
 	user := extractUser(originalArgs)
+	oauth2Bearer := extractOAuth2Bearer(originalArgs)
 	timeout := extractTimeout(originalArgs)
+	connectTimeout := extractConnectTimeout(originalArgs)
 	userAgent := extractUserAgent(originalArgs)
+	requestTarget := extractRequestTarget(originalArgs)
+	writeOut := extractWriteOut(originalArgs)
+	retry, retryDelay := extractRetry(originalArgs)
 	insecure := containsFlag(originalArgs, "-k", "--insecure")
+	caCert := extractCACert(originalArgs)
+	cert, key := extractClientCert(originalArgs)
 	followRedirects := containsFlag(originalArgs, "-L", "--location")
 	include := containsFlag(originalArgs, "-i", "--include")
 	onlyHeaders := containsFlag(originalArgs, "-I", "--head")
+	ignoreContentLength := slices.Contains(originalArgs, "--ignore-content-length")
+	noKeepalive := extractDisableKeepalives(originalArgs)
+	compressed := slices.Contains(originalArgs, "--compressed")
+	http2 := slices.Contains(originalArgs, "--http2")
+	http2PriorKnowledge := slices.Contains(originalArgs, "--http2-prior-knowledge")
+
+	// Like curl, sending data makes the request a POST and uploading a file a PUT, unless -X says otherwise
+	if !containsFlag(originalArgs, "-X", "--request") {
+		if uploadFile != "" {
+			method = "PUT"
+		} else if data != "" || dataAscii != "" || dataBinary != "" {
+			method = "POST"
+		}
+	}
+	fail := containsFlag(originalArgs, "-f", "--fail")
+
+	colors := outputColors(opts.colorScheme)
+
+	// --pipeline sends all the --repeat requests over one connection, made by the first of them
+	if opts.pipeline && opts.transport == nil {
+		transport, err := newPipelineTransport(requestOptions{
+			insecure:            insecure,
+			caCert:              caCert,
+			cert:                cert,
+			key:                 key,
+			http2:               http2,
+			http2PriorKnowledge: http2PriorKnowledge,
+			grpc:                opts.grpc,
+			connectTimeout:      connectTimeout,
+		})
+		if err != nil {
+			return responseStats{}, err
+		}
+		opts.transport = transport
+	}
 
 	// Make the HTTP request using the custom HTTP module
-	err := makeHTTPRequest(localURL, method, headers, data, dataAscii, dataBinary,
-		form, verbose, insecure, user, timeout, followRedirects, -1, // maxRedirects not implemented for fallback
-		userAgent, include, onlyHeaders, "") // output to stdout, not file for fallback
+	var stats responseStats
+	err := makeHTTPRequest(ctx, localURL, requestOptions{
+		method:              method,
+		headers:             headers,
+		data:                data,
+		dataAscii:           dataAscii,
+		dataBinary:          dataBinary,
+		form:                form,
+		uploadFile:          uploadFile,
+		verbose:             verbose,
+		insecure:            insecure,
+		caCert:              caCert,
+		cert:                cert,
+		key:                 key,
+		user:                user,
+		oauth2Bearer:        oauth2Bearer,
+		timeout:             timeout,
+		connectTimeout:      connectTimeout,
+		followRedirects:     followRedirects,
+		maxRedirects:        -1, // maxRedirects not implemented for fallback
+		userAgent:           userAgent,
+		requestTarget:       requestTarget,
+		ignoreContentLength: ignoreContentLength,
+		noKeepalive:         noKeepalive,
+		compressed:          compressed,
+		http2:               http2,
+		http2PriorKnowledge: http2PriorKnowledge,
+		bufferSize:          opts.bufferSize,
+		truncate:            opts.truncate,
+		hexDump:             opts.hexDump,
+		base64:              opts.base64,
+		writeOut:            writeOut,
+		transport:           opts.transport,
+		retry:               retry,
+		retryDelay:          retryDelay,
+		includeHeaders:      include,
+		onlyHeaders:         onlyHeaders,
+		output:              "", // output to stdout, not file for fallback
+		fail:                fail,
+		tee:                 opts.tee,
+		colors:              colors,
+		exitCodes:           opts.statusExitCodes,
+		grpc:                opts.grpc,
+		sse:                 opts.sse,
+		sseEvent:            opts.sseEvent,
+		ndjson:              opts.ndjson,
+		jsonPP:              opts.jsonPP,
+		jq:                  opts.jq,
+		assertStatus:        opts.assertStatus,
+		assertBodyContains:  opts.assertBodyContains,
+		assertHeaders:       opts.assertHeaders,
+		stats:               &stats,
+		outputFormat:        opts.outputFormat,
+		formType:            opts.formType,
+		formatResponse:      opts.formatResponse,
+		pod:                 pod.Name,
+		namespace:           pod.Namespace,
+		outputTemplate:      opts.outputTemplate,
+		url:                 serviceURL,
+		index:               index,
+	})
+
+	// Run the --after-request hook for any response, even one that failed a check, while the port-forward is up
+	if opts.afterRequest != "" && stats.StatusCode != 0 {
+		hookErr := runHook(ctx, opts.afterRequest,
+			fmt.Sprintf("KURL_STATUS_CODE=%d", stats.StatusCode),
+			fmt.Sprintf("KURL_RESPONSE_BYTES=%d", stats.Bytes),
+			fmt.Sprintf("KURL_DURATION_MS=%d", stats.Duration.Milliseconds()),
+		)
+		if hookErr != nil && err == nil {
+			err = fmt.Errorf("--after-request command failed: %w", hookErr)
+		}
+	}
+	return stats, err
+}
+
+// runAllPods sends the request to every pod behind the resource, each through its own port-forward. With
+// --multiple-interface each pod listens on its own loopback address (127.0.0.2, 127.0.0.3, ...) on the same port.
+func runAllPods(ctx context.Context, res *forwardTarget, localPort int, reserved net.Listener, serviceURL string, originalArgs []string, verbose bool, curlAvailable bool, opts *kurlOptions) {
+	targets, err := findAllTargets(ctx, toForwardTarget(res), opts.kube, opts.resolve)
 	if err != nil {
-		fmt.Printf("Error making HTTP request: %v\n", err)
-		close(stopCh)
+		exitOnTimeout(ctx)
+		fmt.Printf("Error finding pods: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.multipleInterface && len(targets) > maxLoopbackAliases {
+		fmt.Printf("Error: %d pods found, but --multiple-interface supports at most %d\n", len(targets), maxLoopbackAliases)
 		os.Exit(1)
 	}
 
-	// Close the stop channel to terminate port-forward
-	close(stopCh)
+	// Listen for the signals before the port-forwards are up so an early Ctrl-C is not lost
+	signalCh := make(chan os.Signal, 1)
+	if opts.forwardOnly {
+		signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	}
+
+	// Start a port-forward per pod
+	localURLs := make([]string, len(targets))
+	sessions := make([]*PortForwardSession, len(targets))
+	for i, target := range targets {
+		host, port, portListener := "localhost", localPort, reserved
+		if opts.multipleInterface {
+			host = fmt.Sprintf("127.0.0.%d", i+2)
+			if i > 0 {
+				portListener = nil
+			}
+		} else if i > 0 {
+			if port, portListener, err = findFreePort(); err != nil {
+				fmt.Printf("Error finding free port: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		_, sessions[i] = startPortForward(ctx, target, host, port, portListener, opts)
+		localURLs[i] = reconstructURLOnHost(serviceURL, host, port)
+	}
+
+	failed := false
+	if opts.forwardOnly {
+		for i, target := range targets {
+			fmt.Printf("%s/%s\t%s\n", target.Namespace, target.Name, localURLs[i])
+		}
+		<-signalCh
+	} else {
+		limiter := newRateLimiter(opts)
+		for i, target := range targets {
+			if limiter != nil && limiter.Wait(ctx) != nil {
+				failed = true
+				break
+			}
+			fmt.Fprintf(os.Stderr, "# pod: %s/%s\n", target.Namespace, target.Name)
+			if curlAvailable && !opts.needsBuiltinClient() {
+				err = runCurl(ctx, originalArgs, localURLs[i], verbose)
+			} else {
+				_, err = runCustomHTTP(ctx, originalArgs, localURLs[i], verbose, target, serviceURL, i+1, opts)
+			}
+			if err != nil {
+				fmt.Printf("Error requesting pod %s: %v\n", target.Name, err)
+				failed = true
+			}
+			endResponse(opts)
+		}
+	}
+
+	reportTraffic(opts)
+
+	// Stop the sessions to terminate the port-forwards
+	for _, session := range sessions {
+		session.Stop()
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// endResponse marks the end of one of several responses written to stdout: a NUL byte with --null-terminated
+func endResponse(opts *kurlOptions) {
+	if opts.nullTerminated {
+		os.Stdout.Write([]byte{0})
+	}
+}
+
+// newRateLimiter returns the limiter that paces requests to the --rate, or nil when there is no --rate
+func newRateLimiter(opts *kurlOptions) *rate.Limiter {
+	if opts.rate == 0 {
+		return nil
+	}
+	return rate.NewLimiter(opts.rate, 1)
+}
+
+// maxLoopbackAliases is the number of addresses from 127.0.0.2 to 127.0.0.254 usable by --multiple-interface
+const maxLoopbackAliases = 253
+
+// runWithExec executes the port forward and runs the --exec command against it, exiting with the command's exit code
+func runWithExec(ctx context.Context, res *forwardTarget, localPort int, reserved net.Listener, serviceURL string, opts *kurlOptions) {
+	// Start port-forward and wait for it to be ready
+	_, session := startPortForward(ctx, toForwardTarget(res), "", localPort, reserved, opts)
+
+	cmd := execCommand(opts.exec, localPort, reconstructURL(serviceURL, localPort))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	err := cmd.Run()
+	reportTraffic(opts)
+
+	// Stop the session to terminate port-forward
+	session.Stop()
+
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Printf("Error executing command: %v\n", err)
+		os.Exit(1)
+	}
 }
 
-// buildCurlCommandFromArgs builds a curl command from original arguments, replacing the URL
-func buildCurlCommandFromArgs(originalArgs []string, newURL string) string {
-	// Start with the curl command
-	args := []string{"curl"}
+// runForwardOnly executes the port forward, prints the local URL and keeps forwarding until interrupted
+func runForwardOnly(ctx context.Context, res *forwardTarget, localPort int, reserved net.Listener, serviceURL string, opts *kurlOptions) {
+	// Listen for the signals before the port-forward is up so an early Ctrl-C is not lost
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+
+	// Start port-forward and wait for it to be ready
+	pod, session := startPortForward(ctx, toForwardTarget(res), "", localPort, reserved, opts)
+
+	localURL := reconstructURL(serviceURL, localPort)
+	fmt.Println(localURL)
 
-	// Add all original arguments (they will be properly escaped)
-	for _, arg := range originalArgs {
-		args = append(args, shellEscape(arg))
+	// A labeled session can be found and stopped by kurl close <label>
+	if opts.label != "" {
+		err := saveSession(savedSession{
+			Label:     opts.label,
+			PID:       os.Getpid(),
+			Target:    serviceURL,
+			Pod:       pod.Namespace + "/" + pod.Name,
+			LocalPort: localPort,
+			URL:       localURL,
+			StartedAt: time.Now(),
+		})
+		if err != nil {
+			fmt.Printf("Error saving session: %v\n", err)
+			session.Stop()
+			os.Exit(1)
+		}
+		defer removeSession(opts.label)
 	}
 
-	// Add the new local URL
-	args = append(args, shellEscape(newURL))
+	// Block until interrupted, then stop the session to terminate port-forward
+	<-signalCh
+	reportTraffic(opts)
+	session.Stop()
+}
 
-	return strings.Join(args, " ")
+// execCommand prepares the --exec shell command, telling it where the port-forward listens via the environment
+func execCommand(command string, localPort int, localURL string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("KURL_LOCAL_PORT=%d", localPort),
+		"KURL_LOCAL_URL="+localURL,
+	)
+	return cmd
 }
 
-// Helper functions to extract specific flags from arguments for fallback HTTP client
+// runHook runs a --before-forward or --after-request shell command with the given extra environment. Its output
+// goes to stderr so that it does not mix with the response.
+func runHook(ctx context.Context, command string, env ...string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// buildCurlArgs builds the curl arguments from the original arguments, with newURL as the URL
+func buildCurlArgs(originalArgs []string, newURL string) []string {
+	args := make([]string, 0, len(originalArgs)+1)
+	args = append(args, originalArgs...)
+	return append(args, newURL)
+}
+
+// shellCommand formats the command and its arguments for display, each escaped for a shell
+func shellCommand(name string, args []string) string {
+	words := []string{name}
+	for _, arg := range args {
+		words = append(words, shellEscape(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// Helper functions to extract specific flags from arguments for fallback HTTP client.
+// Like curl, when a flag is given more than once the last one wins.
 func extractMethod(args []string) string {
+	method := "GET" // default
 	for i, arg := range args {
 		if arg == "-X" || arg == "--request" {
 			if i+1 < len(args) {
-				return args[i+1]
+				method = args[i+1]
 			}
 		}
 		// Handle -X=method format
 		if strings.HasPrefix(arg, "-X=") || strings.HasPrefix(arg, "--request=") {
 			parts := strings.SplitN(arg, "=", 2)
 			if len(parts) == 2 {
-				return parts[1]
+				method = parts[1]
+			}
+		}
+	}
+	return method
+}
+
+// extractURL removes every --url flag from args, returning the last URL given and the remaining args
+func extractURL(args []string) (string, []string) {
+	var serviceURL string
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--url" {
+			if i+1 < len(args) {
+				i++
+				serviceURL = args[i]
 			}
+			continue
+		}
+		// Handle = format
+		if strings.HasPrefix(arg, "--url=") {
+			serviceURL = strings.TrimPrefix(arg, "--url=")
+			continue
 		}
+		rest = append(rest, arg)
 	}
-	return "GET" // default
+	return serviceURL, rest
 }
 
+// extractHeaders returns the -H values; values without a colon are not headers and are skipped with a warning
 func extractHeaders(args []string) []string {
 	var headers []string
 	for i, arg := range args {
+		var header string
 		if arg == "-H" || arg == "--header" {
-			if i+1 < len(args) {
-				headers = append(headers, args[i+1])
+			if i+1 >= len(args) {
+				continue
 			}
+			header = args[i+1]
+		} else if strings.HasPrefix(arg, "-H=") || strings.HasPrefix(arg, "--header=") {
+			// Handle -H=header format
+			_, header, _ = strings.Cut(arg, "=")
+		} else {
+			continue
 		}
-		// Handle -H=header format
-		if strings.HasPrefix(arg, "-H=") || strings.HasPrefix(arg, "--header=") {
-			parts := strings.SplitN(arg, "=", 2)
-			if len(parts) == 2 {
-				headers = append(headers, parts[1])
-			}
+
+		if !strings.Contains(header, ":") {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring header %q without a colon\n", header)
+			continue
 		}
+		headers = append(headers, header)
 	}
 	return headers
 }
@@ -303,6 +1021,79 @@ func extractData(args []string) (string, string, string) {
 	return data, dataAscii, dataBinary
 }
 
+// extractWriteOut returns the -w/--write-out format
+func extractWriteOut(args []string) string {
+	var writeOut string
+	for i, arg := range args {
+		if arg == "-w" || arg == "--write-out" {
+			if i+1 < len(args) {
+				writeOut = args[i+1]
+			}
+		}
+		// Handle = format
+		if strings.HasPrefix(arg, "-w=") || strings.HasPrefix(arg, "--write-out=") {
+			writeOut = strings.SplitN(arg, "=", 2)[1]
+		}
+	}
+	return writeOut
+}
+
+// extractCACert returns the --cacert file the server's certificate is verified against
+func extractCACert(args []string) string {
+	var caCert string
+	for i, arg := range args {
+		if arg == "--cacert" {
+			if i+1 < len(args) {
+				caCert = args[i+1]
+			}
+		}
+		// Handle = format
+		if strings.HasPrefix(arg, "--cacert=") {
+			caCert = strings.SplitN(arg, "=", 2)[1]
+		}
+	}
+	return caCert
+}
+
+// extractClientCert returns the -E/--cert client certificate file and the --key file of its private key
+func extractClientCert(args []string) (cert, key string) {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "-E" && name != "--cert" && name != "--key" {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				continue
+			}
+			value = args[i+1]
+		}
+		if name == "--key" {
+			key = value
+		} else {
+			cert = value
+		}
+	}
+	return cert, key
+}
+
+// extractUploadFile returns the -T/--upload-file path, - meaning stdin
+func extractUploadFile(args []string) string {
+	var uploadFile string
+	for i, arg := range args {
+		if arg == "-T" || arg == "--upload-file" {
+			if i+1 < len(args) {
+				uploadFile = args[i+1]
+			}
+		}
+		// Handle = format
+		if strings.HasPrefix(arg, "-T=") || strings.HasPrefix(arg, "--upload-file=") {
+			uploadFile = strings.SplitN(arg, "=", 2)[1]
+		}
+	}
+	return uploadFile
+}
+
 func extractForm(args []string) []string {
 	var forms []string
 	for i, arg := range args {
@@ -323,61 +1114,156 @@ func extractForm(args []string) []string {
 }
 
 func extractUser(args []string) string {
+	var user string
 	for i, arg := range args {
 		if arg == "-u" || arg == "--user" {
 			if i+1 < len(args) {
-				return args[i+1]
+				user = args[i+1]
 			}
 		}
 		// Handle = format
 		if strings.HasPrefix(arg, "-u=") || strings.HasPrefix(arg, "--user=") {
 			parts := strings.SplitN(arg, "=", 2)
 			if len(parts) == 2 {
-				return parts[1]
+				user = parts[1]
+			}
+		}
+	}
+	return user
+}
+
+// extractOAuth2Bearer returns the --oauth2-bearer token sent as an Authorization: Bearer header
+func extractOAuth2Bearer(args []string) string {
+	var token string
+	for i, arg := range args {
+		if arg == "--oauth2-bearer" {
+			if i+1 < len(args) {
+				token = args[i+1]
 			}
 		}
+		// Handle = format
+		if strings.HasPrefix(arg, "--oauth2-bearer=") {
+			token = strings.SplitN(arg, "=", 2)[1]
+		}
 	}
-	return ""
+	return token
 }
 
-func extractTimeout(args []string) int {
+// decodeBase64 decodes the --base64-decode body s, ignoring the line breaks base64 tools wrap their output with
+func decodeBase64(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	return string(decoded), err
+}
+
+// extractDisableKeepalives reports whether --no-keepalive is given, which makes the built-in client open a new
+// connection for every request
+func extractDisableKeepalives(args []string) bool {
+	return slices.Contains(args, "--no-keepalive")
+}
+
+// extractTimeout returns the -m/--max-time value, which like curl's may be fractional. Invalid values are ignored.
+func extractTimeout(args []string) time.Duration {
+	var timeout time.Duration
 	for i, arg := range args {
 		if arg == "-m" || arg == "--max-time" {
 			if i+1 < len(args) {
-				if timeout, err := strconv.Atoi(args[i+1]); err == nil {
-					return timeout
+				if t, err := parseSeconds(arg, args[i+1]); err == nil {
+					timeout = t
 				}
 			}
 		}
 		// Handle = format
 		if strings.HasPrefix(arg, "-m=") || strings.HasPrefix(arg, "--max-time=") {
-			parts := strings.SplitN(arg, "=", 2)
-			if len(parts) == 2 {
-				if timeout, err := strconv.Atoi(parts[1]); err == nil {
-					return timeout
-				}
+			name, value, _ := strings.Cut(arg, "=")
+			if t, err := parseSeconds(name, value); err == nil {
+				timeout = t
+			}
+		}
+	}
+	return timeout
+}
+
+// extractConnectTimeout returns the --connect-timeout value, which bounds only setting up the connection. Like
+// -m/--max-time it may be fractional, and invalid values are ignored.
+func extractConnectTimeout(args []string) time.Duration {
+	var timeout time.Duration
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "--connect-timeout" {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				continue
 			}
+			value = args[i+1]
+		}
+		if t, err := parseSeconds(name, value); err == nil {
+			timeout = t
 		}
 	}
-	return 0
+	return timeout
+}
+
+func extractRequestTarget(args []string) string {
+	var target string
+	for i, arg := range args {
+		if arg == "--request-target" {
+			if i+1 < len(args) {
+				target = args[i+1]
+			}
+		}
+		// Handle = format
+		if strings.HasPrefix(arg, "--request-target=") {
+			target = strings.TrimPrefix(arg, "--request-target=")
+		}
+	}
+	return target
+}
+
+// extractRetry returns the --retry count and the --retry-delay, which is zero when not given
+func extractRetry(args []string) (int, time.Duration) {
+	var retry int
+	var delay time.Duration
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "--retry" && name != "--retry-delay" {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				continue
+			}
+			value = args[i+1]
+		}
+		if name == "--retry" {
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				retry = n
+			}
+		} else if d, err := parseSeconds(name, value); err == nil {
+			delay = d
+		}
+	}
+	return retry, delay
 }
 
 func extractUserAgent(args []string) string {
+	var userAgent string
 	for i, arg := range args {
 		if arg == "-A" || arg == "--user-agent" {
 			if i+1 < len(args) {
-				return args[i+1]
+				userAgent = args[i+1]
 			}
 		}
 		// Handle = format
 		if strings.HasPrefix(arg, "-A=") || strings.HasPrefix(arg, "--user-agent=") {
 			parts := strings.SplitN(arg, "=", 2)
 			if len(parts) == 2 {
-				return parts[1]
+				userAgent = parts[1]
 			}
 		}
 	}
-	return ""
+	return userAgent
 }
 
 // shellEscape escapes a string for use in a shell command
@@ -394,16 +1280,23 @@ func shellEscape(s string) string {
 
 // reconstructURL properly reconstructs the URL to use localhost and the local port
 func reconstructURL(originalURL string, localPort int) string {
+	return reconstructURLOnHost(originalURL, "localhost", localPort)
+}
+
+// reconstructURLOnHost reconstructs the URL to use the given local host and port
+func reconstructURLOnHost(originalURL string, host string, localPort int) string {
+	// The local port replaces a named port too
+	originalURL, _ = cutNamedPort(originalURL)
 	parsedURL, err := url.Parse(originalURL)
 	if err != nil {
 		// If we can't parse the URL, return the original
 		return originalURL
 	}
 
-	// Reconstruct the URL with localhost and localPort
+	// Reconstruct the URL with the local host and port
 	newURL := &url.URL{
 		Scheme:   parsedURL.Scheme,
-		Host:     fmt.Sprintf("localhost:%d", localPort),
+		Host:     net.JoinHostPort(host, strconv.Itoa(localPort)),
 		Path:     parsedURL.Path,
 		RawQuery: parsedURL.RawQuery,
 		Fragment: parsedURL.Fragment,
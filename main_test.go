@@ -0,0 +1,1341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func FuzzShellEscape(f *testing.F) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		f.Skip("sh not available")
+	}
+
+	seeds := []string{
+		"",
+		"simple",
+		"with spaces",
+		"it's quoted",
+		`"double quoted"`,
+		"$HOME",
+		"${PATH}",
+		"$(whoami)",
+		"`whoami`",
+		`back\slash`,
+		"new\nline",
+		"tab\tseparated",
+		"semi;colon && echo pwned",
+		"'",
+		"''",
+		`\'`,
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		// Arguments cannot carry NUL bytes through exec, so there is nothing to round-trip
+		if strings.ContainsRune(s, 0) {
+			t.Skip()
+		}
+
+		out, err := exec.Command("sh", "-c", "printf '%s' "+shellEscape(s)).Output()
+		if err != nil {
+			t.Fatalf("shell failed for %q: %v", s, err)
+		}
+		if string(out) != s {
+			t.Errorf("shellEscape(%q) round-tripped to %q", s, string(out))
+		}
+	})
+}
+
+func FuzzBuildCurlArgs(f *testing.F) {
+	for _, seed := range []string{"", "simple", "with spaces", "key=value", "http://svc.ns.svc:8080/api", "$(whoami)"} {
+		f.Add(seed)
+	}
+
+	const localURL = "http://localhost:12345/api"
+	f.Fuzz(func(t *testing.T, s string) {
+		// Anything starting with a dash could be taken for a flag rather than the value of -d or -H
+		if strings.HasPrefix(s, "-") {
+			t.Skip()
+		}
+
+		_, curlArgs, err := extractKurlFlags([]string{"--context", "staging", "-d", s, "--kubeconfig=/tmp/config", "-H", s})
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", s, err)
+		}
+
+		// The kurl flags are dropped, the curl ones passed on as given and the local URL requested
+		args := buildCurlArgs(curlArgs, localURL)
+		if expected := []string{"-d", s, "-H", s, localURL}; !reflect.DeepEqual(args, expected) {
+			t.Errorf("Expected %q, got %q", expected, args)
+		}
+	})
+}
+
+func BenchmarkBuildCurlArgs(b *testing.B) {
+	var args []string
+	for i := 0; i < 50; i++ {
+		args = append(args, "-H", fmt.Sprintf("X-Header-%d: value-%d", i, i))
+	}
+	args = append(args, "-d", strings.Repeat("x'y", 10*1024/3))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildCurlArgs(args, "http://localhost:12345/api/resource")
+	}
+}
+
+func TestExtractKurlFlags(t *testing.T) {
+	args := []string{"-H", "Accept: application/json", "--pod-label-selector", "version=1.2", "-v", "http://svc.ns.svc:8080"}
+	opts, curlArgs, err := extractKurlFlags(args)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"-H", "Accept: application/json", "-v", "http://svc.ns.svc:8080"}
+	if !reflect.DeepEqual(curlArgs, expected) {
+		t.Errorf("Expected curl args %v, got %v", expected, curlArgs)
+	}
+	if opts.resolve.podSelector == nil || opts.resolve.podSelector.String() != "version=1.2" {
+		t.Errorf("Expected pod selector 'version=1.2', got %v", opts.resolve.podSelector)
+	}
+
+	// The --flag=value form is accepted too
+	opts, curlArgs, err = extractKurlFlags([]string{"--pod-label-selector=tier in (web,api)", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(curlArgs) != 1 || opts.resolve.podSelector.String() != "tier in (api,web)" {
+		t.Errorf("Unexpected result: args=%v selector=%v", curlArgs, opts.resolve.podSelector)
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--pod-label-selector"}); err == nil {
+		t.Errorf("Expected error for missing flag value, got nil")
+	}
+	if _, _, err := extractKurlFlags([]string{"--pod-label-selector", "=invalid"}); err == nil {
+		t.Errorf("Expected error for invalid selector, got nil")
+	}
+}
+
+func TestExtractKurlFlagsContext(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"-s", "--context", "staging", "http://svc.ns.svc:8080"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.kube.context != "staging" {
+		t.Errorf("Expected context 'staging', got %q", opts.kube.context)
+	}
+	if args := buildCurlArgs(curlArgs, "http://localhost:1234"); strings.Contains(strings.Join(args, " "), "context") {
+		t.Errorf("Expected --context to be kept out of the curl arguments, got %q", args)
+	}
+}
+
+func TestExtractKurlFlagsKubeconfig(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--kubeconfig", "/tmp/other.yaml", "-s", "http://svc.ns.svc:8080"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.kube.kubeconfig != "/tmp/other.yaml" {
+		t.Errorf("Expected kubeconfig '/tmp/other.yaml', got %q", opts.kube.kubeconfig)
+	}
+	if args := buildCurlArgs(curlArgs, "http://localhost:1234"); strings.Contains(strings.Join(args, " "), "kubeconfig") {
+		t.Errorf("Expected --kubeconfig to be kept out of the curl arguments, got %q", args)
+	}
+}
+
+func TestExtractKurlFlagsImpersonation(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--impersonate", "jane", "--impersonate-group=developers", "--impersonate-group", "qa", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Expected only the URL to remain, got %v", curlArgs)
+	}
+	if opts.kube.impersonate != "jane" || !reflect.DeepEqual(opts.kube.impersonateGroups, []string{"developers", "qa"}) {
+		t.Errorf("Unexpected impersonation options: %+v", opts.kube)
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--impersonate-group", "qa", "http://svc"}); err == nil {
+		t.Errorf("Expected error for --impersonate-group without --impersonate, got nil")
+	}
+}
+
+func TestExtractKurlFlagsServiceAccount(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--service-account", "port-forwarder", "--namespace=tools", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Expected only the URL to remain, got %v", curlArgs)
+	}
+	if opts.kube.serviceAccount != "port-forwarder" || opts.kube.serviceAccountNamespace != "tools" {
+		t.Errorf("Unexpected service account options: %+v", opts.kube)
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--namespace", "tools", "http://svc"}); err == nil {
+		t.Errorf("Expected error for --namespace without --service-account, got nil")
+	}
+}
+
+func TestExecCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	cmd := execCommand(`printf '%s %s' "$KURL_LOCAL_PORT" "$KURL_LOCAL_URL"`, 12345, "http://localhost:12345/api")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != "12345 http://localhost:12345/api" {
+		t.Errorf("Expected port and URL in the environment, got %q", string(out))
+	}
+}
+
+func TestExtractKurlFlagsForwardOnly(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--forward-only", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !opts.forwardOnly || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Unexpected result: forwardOnly=%v args=%v", opts.forwardOnly, curlArgs)
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--forward-only", "--exec", "true", "http://svc"}); err == nil {
+		t.Errorf("Expected error for --forward-only with --exec, got nil")
+	}
+
+	opts, curlArgs, err = extractKurlFlags([]string{"--forward-only", "--label", "db", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.label != "db" || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Unexpected result: label=%q args=%v", opts.label, curlArgs)
+	}
+	if _, _, err := extractKurlFlags([]string{"--label", "db", "http://svc"}); err == nil {
+		t.Errorf("Expected error for --label without --forward-only, got nil")
+	}
+	if _, _, err := extractKurlFlags([]string{"--forward-only", "--label", "../db", "http://svc"}); err == nil {
+		t.Errorf("Expected error for an invalid --label, got nil")
+	}
+}
+
+func TestReconstructURLOnHost(t *testing.T) {
+	got := reconstructURLOnHost("http://svc.ns.svc:8080/api?limit=1", "127.0.0.3", 18080)
+	if got != "http://127.0.0.3:18080/api?limit=1" {
+		t.Errorf("Unexpected URL: %s", got)
+	}
+
+	got = reconstructURLOnHost("http://svc.ns.svc:http/api", "localhost", 18080)
+	if got != "http://localhost:18080/api" {
+		t.Errorf("Unexpected URL for a named port: %s", got)
+	}
+
+	got = reconstructURL("https://svc.ns.svc/health", 18443)
+	if got != "https://localhost:18443/health" {
+		t.Errorf("Unexpected URL: %s", got)
+	}
+}
+
+func TestExtractKurlFlagsAllPods(t *testing.T) {
+	opts, _, err := extractKurlFlags([]string{"--all-pods", "--multiple-interface", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !opts.allPods || !opts.multipleInterface {
+		t.Errorf("Expected --all-pods and --multiple-interface to be set, got %+v", opts)
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--multiple-interface", "http://svc"}); err == nil {
+		t.Errorf("Expected error for --multiple-interface without --all-pods, got nil")
+	}
+}
+
+func TestInferURLScheme(t *testing.T) {
+	testCases := []struct {
+		arg      string
+		expected string
+		ok       bool
+	}{
+		{"mysvc.mynamespace.svc:8080/api", "http://mysvc.mynamespace.svc:8080/api", true},
+		{"mysvc.mynamespace.svc", "http://mysvc.mynamespace.svc", true},
+		{"mysvc.mynamespace.svc:http/api", "http://mysvc.mynamespace.svc:http/api", true},
+		{"my-app.default.deploy:80?x=1", "http://my-app.default.deploy:80?x=1", true},
+		{"myjob.mynamespace.job:8080", "http://myjob.mynamespace.job:8080", true},
+		{"mysvc.mynamespace.cronjob:8080", "", false},
+		{"mysvc:8080", "", false},
+		{"localhost:8080", "", false},
+		{"Content-Type: application/json", "", false},
+		{"example.com", "", false},
+		{"-v", "", false},
+	}
+
+	for _, tc := range testCases {
+		got, ok := inferURLScheme(tc.arg)
+		if ok != tc.ok || got != tc.expected {
+			t.Errorf("inferURLScheme(%q) = %q, %v; expected %q, %v", tc.arg, got, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestExtractKurlFlagsNoResolve(t *testing.T) {
+	opts, _, err := extractKurlFlags([]string{"--no-resolve", "http://my-pod.ns.svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !opts.resolve.noResolve {
+		t.Errorf("Expected --no-resolve to be set")
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--no-resolve", "--namespace-all", "http://my-pod"}); err == nil {
+		t.Errorf("Expected error for --no-resolve with --namespace-all, got nil")
+	}
+}
+
+func TestParseTargetPodLabelSelector(t *testing.T) {
+	opts, _, err := extractKurlFlags([]string{"--pod-label-selector", "version=2"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := parseTarget("http://web.default.svc:8080/", opts); err != nil {
+		t.Errorf("Unexpected error for a service URL: %v", err)
+	}
+	if _, err := parseTarget("http://my-pod.default.pod:8080/", opts); err == nil {
+		t.Errorf("Expected error for --pod-label-selector with a pod URL, got nil")
+	}
+}
+
+func TestExtractKurlFlagsK8sTimeout(t *testing.T) {
+	opts, _, err := extractKurlFlags([]string{"http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.kube.apiTimeout != defaultAPITimeout {
+		t.Errorf("Expected default API timeout %v, got %v", defaultAPITimeout, opts.kube.apiTimeout)
+	}
+
+	opts, curlArgs, err := extractKurlFlags([]string{"--k8s-timeout", "2.5", "-m", "30", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.kube.apiTimeout != 2500*time.Millisecond {
+		t.Errorf("Expected API timeout 2.5s, got %v", opts.kube.apiTimeout)
+	}
+	if !reflect.DeepEqual(curlArgs, []string{"-m", "30", "http://svc"}) {
+		t.Errorf("Expected -m to be left for curl, got %v", curlArgs)
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--k8s-timeout=soon", "http://svc"}); err == nil {
+		t.Errorf("Expected error for invalid --k8s-timeout, got nil")
+	}
+}
+
+func TestParseCurlConfig(t *testing.T) {
+	config := `# comment lines and blank lines are skipped
+
+--header "X-Foo: bar"
+header = "Accept: application/json"
+-X POST
+data: "{\"key\": \"va\tlue\"}"
+silent
+url = "http://mysvc.mynamespace.svc:8080/api"
+`
+	args, err := parseCurlConfig(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		"--header", "X-Foo: bar",
+		"--header", "Accept: application/json",
+		"-X", "POST",
+		"--data", "{\"key\": \"va\tlue\"}",
+		"--silent",
+		"--url", "http://mysvc.mynamespace.svc:8080/api",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+
+	if _, err := parseCurlConfig(strings.NewReader(`header = "unterminated`)); err == nil {
+		t.Errorf("Expected error for unterminated quote, got nil")
+	}
+}
+
+func TestExpandCurlConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "curlrc")
+	if err := os.WriteFile(path, []byte("request = PUT\nuser = config:secret\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	args, err := expandCurlConfig([]string{"-K", path, "-u", "cli:secret", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"--request", "PUT", "--user", "config:secret", "-u", "cli:secret", "http://svc"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+
+	// The command line takes precedence over the config file
+	if method := extractMethod(args); method != "PUT" {
+		t.Errorf("Expected method from config file, got %s", method)
+	}
+	if user := extractUser(args); user != "cli:secret" {
+		t.Errorf("Expected user from command line, got %s", user)
+	}
+
+	if _, err := expandCurlConfig([]string{"--config=" + filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Errorf("Expected error for missing config file, got nil")
+	}
+}
+
+func TestExtractURL(t *testing.T) {
+	url, rest := extractURL([]string{"-H", "Referer: http://other.ns.svc", "--url", "http://first.ns.svc", "--url=http://svc.ns.svc:8080/api", "-v"})
+	if url != "http://svc.ns.svc:8080/api" {
+		t.Errorf("Expected the last --url to win, got %s", url)
+	}
+	if !reflect.DeepEqual(rest, []string{"-H", "Referer: http://other.ns.svc", "-v"}) {
+		t.Errorf("Expected --url to be removed, got %v", rest)
+	}
+
+	url, rest = extractURL([]string{"-v", "http://svc.ns.svc"})
+	if url != "" || len(rest) != 2 {
+		t.Errorf("Expected no URL and unchanged args, got %q %v", url, rest)
+	}
+}
+
+func TestExtractKurlFlagsShowPod(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--show-pod", "-s", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !opts.showPod || !reflect.DeepEqual(curlArgs, []string{"-s", "http://svc"}) {
+		t.Errorf("Unexpected result: showPod=%v args=%v", opts.showPod, curlArgs)
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	testCases := []struct {
+		arg      string
+		expected bool
+	}{
+		{"http://foo", true},
+		{"https://foo", true},
+		{"HTTP://foo", true},
+		{"Https://foo", true},
+		{"ftp://foo", false},
+		{"localhost:8080", false},
+		{"", false},
+		{"/api/resource", false},
+		{"http://svc.ns.svc:8080/path", true},
+	}
+
+	for _, tc := range testCases {
+		if got := isURL(tc.arg); got != tc.expected {
+			t.Errorf("isURL(%q) = %v; expected %v", tc.arg, got, tc.expected)
+		}
+	}
+}
+
+func TestExtractHeaders(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{"single", []string{"-H", "Accept: application/json", "http://svc"}, []string{"Accept: application/json"}},
+		{"multiple", []string{"-H", "Accept: application/json", "--header", "X-Request-Id: 42"}, []string{"Accept: application/json", "X-Request-Id: 42"}},
+		{"colon in value", []string{"-H", "X-Time: 12:30:00"}, []string{"X-Time: 12:30:00"}},
+		{"equals form", []string{"--header=X-Foo: bar"}, []string{"X-Foo: bar"}},
+		{"no headers", []string{"-v", "http://svc"}, nil},
+		{"without colon", []string{"-H", "not-a-header", "-H", "X-Foo: bar"}, []string{"X-Foo: bar"}},
+	}
+
+	for _, tc := range testCases {
+		if got := extractHeaders(tc.args); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestExtractForm(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{"single", []string{"-F", "key=value", "http://svc"}, []string{"key=value"}},
+		{"multiple", []string{"-F", "a=1", "--form", "b=2"}, []string{"a=1", "b=2"}},
+		{"file reference", []string{"-F", "key=@file.txt"}, []string{"key=@file.txt"}},
+		{"equals form", []string{"--form=key=value"}, []string{"key=value"}},
+		{"no form", []string{"-d", "key=value", "http://svc"}, nil},
+	}
+
+	for _, tc := range testCases {
+		if got := extractForm(tc.args); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestExtractUser(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-u", "user:pass", "http://svc"}, "user:pass"},
+		{[]string{"-u", "user"}, "user"},
+		{[]string{"--user=user:pass"}, "user:pass"},
+		{[]string{"--user", "user:pass:with:colons"}, "user:pass:with:colons"},
+		{[]string{"-v", "http://svc"}, ""},
+	}
+
+	for _, tc := range testCases {
+		if got := extractUser(tc.args); got != tc.expected {
+			t.Errorf("extractUser(%q) = %q; expected %q", tc.args, got, tc.expected)
+		}
+	}
+}
+
+func TestExtractOAuth2Bearer(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--oauth2-bearer", "token", "http://svc"}, "token"},
+		{[]string{"--oauth2-bearer=a=b"}, "a=b"},
+		{[]string{"-u", "user", "http://svc"}, ""},
+	}
+
+	for _, tc := range testCases {
+		if got := extractOAuth2Bearer(tc.args); got != tc.expected {
+			t.Errorf("extractOAuth2Bearer(%q) = %q; expected %q", tc.args, got, tc.expected)
+		}
+	}
+}
+
+func TestExtractWriteOut(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-w", "%{http_code}", "http://svc"}, "%{http_code}"},
+		{[]string{"--write-out", "%{time_total}\\n"}, "%{time_total}\\n"},
+		{[]string{"--write-out=a=b"}, "a=b"},
+		{[]string{"-v", "http://svc"}, ""},
+	}
+
+	for _, tc := range testCases {
+		if got := extractWriteOut(tc.args); got != tc.expected {
+			t.Errorf("extractWriteOut(%q) = %q; expected %q", tc.args, got, tc.expected)
+		}
+	}
+}
+
+func TestExtractConnectTimeout(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected time.Duration
+	}{
+		{[]string{"--connect-timeout", "3", "http://svc"}, 3 * time.Second},
+		{[]string{"--connect-timeout=0.5"}, 500 * time.Millisecond},
+		{[]string{"--connect-timeout", "soon"}, 0},
+		{[]string{"-m", "10", "http://svc"}, 0},
+	}
+
+	for _, tc := range testCases {
+		if got := extractConnectTimeout(tc.args); got != tc.expected {
+			t.Errorf("extractConnectTimeout(%q) = %v; expected %v", tc.args, got, tc.expected)
+		}
+	}
+}
+
+func TestExtractUploadFile(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-T", "file.bin", "http://svc"}, "file.bin"},
+		{[]string{"--upload-file", "-"}, "-"},
+		{[]string{"--upload-file=dir/file.bin"}, "dir/file.bin"},
+		{[]string{"-d", "x", "http://svc"}, ""},
+	}
+
+	for _, tc := range testCases {
+		if got := extractUploadFile(tc.args); got != tc.expected {
+			t.Errorf("extractUploadFile(%q) = %q; expected %q", tc.args, got, tc.expected)
+		}
+	}
+}
+
+func TestExtractCACert(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--cacert", "ca.crt", "https://svc"}, "ca.crt"},
+		{[]string{"--cacert=certs/ca.pem"}, "certs/ca.pem"},
+		{[]string{"-k", "https://svc"}, ""},
+	}
+
+	for _, tc := range testCases {
+		if got := extractCACert(tc.args); got != tc.expected {
+			t.Errorf("extractCACert(%q) = %q; expected %q", tc.args, got, tc.expected)
+		}
+	}
+}
+
+func TestExtractClientCert(t *testing.T) {
+	testCases := []struct {
+		args      []string
+		cert, key string
+	}{
+		{[]string{"--cert", "client.crt", "--key", "client.key", "https://svc"}, "client.crt", "client.key"},
+		{[]string{"-E", "client.pem"}, "client.pem", ""},
+		{[]string{"--cert=client.crt", "--key=client.key"}, "client.crt", "client.key"},
+		{[]string{"--cacert", "ca.crt"}, "", ""},
+	}
+
+	for _, tc := range testCases {
+		if cert, key := extractClientCert(tc.args); cert != tc.cert || key != tc.key {
+			t.Errorf("extractClientCert(%q) = %q, %q; expected %q, %q", tc.args, cert, key, tc.cert, tc.key)
+		}
+	}
+}
+
+func TestExtractTimeout(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected time.Duration
+	}{
+		{[]string{"-m", "30", "http://svc"}, 30 * time.Second},
+		{[]string{"--max-time=60"}, 60 * time.Second},
+		{[]string{"-m", "1.5"}, 1500 * time.Millisecond},
+		{[]string{"-m", "0"}, 0},
+		{[]string{"-m", "-1"}, 0},
+		{[]string{"-v", "http://svc"}, 0},
+	}
+
+	for _, tc := range testCases {
+		if got := extractTimeout(tc.args); got != tc.expected {
+			t.Errorf("extractTimeout(%q) = %v; expected %v", tc.args, got, tc.expected)
+		}
+	}
+}
+
+func TestContainsFlagCombinedFlags(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"-vsi"}, true},
+		{[]string{"-si"}, false},
+		{[]string{"-v"}, true},
+		{[]string{"--verbose"}, true},
+		// Letters after a flag that takes a value belong to that value
+		{[]string{"-XvPOST"}, false},
+		{[]string{"-sXPOST", "-H", "X-Verbose: 1"}, false},
+		{[]string{"--silent"}, false},
+	}
+
+	for _, tc := range testCases {
+		if got := containsFlag(tc.args, "-v", "--verbose"); got != tc.expected {
+			t.Errorf("containsFlag(%q, -v, --verbose) = %v; expected %v", tc.args, got, tc.expected)
+		}
+	}
+}
+
+func TestRunWithCustomHTTPExitCode(t *testing.T) {
+	// In the subprocess, make the request and exit the way kurl does
+	if localURL := os.Getenv("KURL_TEST_REQUEST_URL"); localURL != "" {
+		opts, args, err := extractKurlFlags(strings.Fields(os.Getenv("KURL_TEST_REQUEST_ARGS")))
+		if err != nil {
+			os.Exit(1)
+		}
+		pod := &ForwardTarget{Name: "my-pod", Namespace: "default"}
+		if _, err := runCustomHTTP(context.Background(), args, localURL, false, pod, localURL, 1, opts); err != nil {
+			os.Exit(exitCode(err))
+		}
+		os.Exit(0)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		args     string
+		expected int
+	}{
+		{"--fail", 22},
+		{"-sf", 22},
+		{"", 0},
+		{"--response-code-to-exit 404=5", 5},
+		{"--fail --response-code-to-exit 404=5,503=6", 5},
+		{"--response-code-to-exit 503=6", 0},
+		{"--assert-status 200", 22},
+		{"--assert-status 200,404", 0},
+	}
+
+	for _, tc := range testCases {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestRunWithCustomHTTPExitCode$")
+		cmd.Env = append(os.Environ(), "KURL_TEST_REQUEST_URL="+server.URL, "KURL_TEST_REQUEST_ARGS="+tc.args)
+		err := cmd.Run()
+
+		code := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatalf("Failed to run subprocess: %v", err)
+		}
+		if code != tc.expected {
+			t.Errorf("Expected exit code %d with args %q, got %d", tc.expected, tc.args, code)
+		}
+	}
+}
+
+func TestReportRequestError(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected string
+	}{
+		{&HTTPError{StatusCode: 404, ExitCode: 5}, ""},
+		{&HTTPError{StatusCode: 404}, "Error making HTTP request: "},
+		{&AssertionError{Failures: []string{"status 404"}}, "Error: "},
+		{errors.New("connection refused"), "Error making HTTP request: connection refused\n"},
+	}
+
+	for _, tc := range testCases {
+		var out strings.Builder
+		reportRequestError(&out, tc.err)
+		if !strings.HasPrefix(out.String(), tc.expected) || (tc.expected == "") != (out.Len() == 0) {
+			t.Errorf("reportRequestError(%v) printed %q; expected it to start with %q", tc.err, out.String(), tc.expected)
+		}
+	}
+}
+
+func TestBuildCurlArgs(t *testing.T) {
+	args := []string{"-H", "X-Test: ;rm -rf /", "-d", "it's $HOME", "--data-urlencode", "name=with spaces"}
+	got := buildCurlArgs(args, "http://localhost:8080/;rm -rf /")
+
+	// Every argument stays one argument, unescaped, with the URL last
+	expected := []string{"-H", "X-Test: ;rm -rf /", "-d", "it's $HOME", "--data-urlencode", "name=with spaces", "http://localhost:8080/;rm -rf /"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if len(args) != 6 {
+		t.Errorf("Expected the original arguments to be left alone, got %q", args)
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	// The printed command runs the same arguments when pasted into a shell
+	args := []string{"%s|", "with spaces", "it's", "$HOME", ";rm -rf /", ""}
+	out, err := exec.Command("sh", "-c", shellCommand("printf", args)).Output()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "with spaces|it's|$HOME|;rm -rf /||"; string(out) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(out))
+	}
+}
+
+func TestExtractKurlFlagsResponseCodeToExit(t *testing.T) {
+	opts, _, err := extractKurlFlags([]string{"--response-code-to-exit", "404=5, 503=6", "http://svc.ns.svc:8080"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[int]int{404: 5, 503: 6}
+	if !reflect.DeepEqual(opts.statusExitCodes, expected) {
+		t.Errorf("Expected %v, got %v", expected, opts.statusExitCodes)
+	}
+	if !opts.needsBuiltinClient() {
+		t.Errorf("Expected --response-code-to-exit to need the built-in client")
+	}
+
+	for _, mapping := range []string{"404", "abc=5", "404=abc", "42=5", "404=0", "404=256"} {
+		if _, _, err := extractKurlFlags([]string{"--response-code-to-exit=" + mapping}); err == nil {
+			t.Errorf("Expected error for --response-code-to-exit %q, got nil", mapping)
+		}
+	}
+}
+
+func TestGrpcArgs(t *testing.T) {
+	testCases := []struct {
+		name       string
+		args       []string
+		serviceURL string
+		expected   []string
+	}{
+		{
+			"http",
+			[]string{"-d", "{}"},
+			"http://svc.ns.svc:8080/pkg.Service/Method",
+			[]string{"-H", "Content-Type: application/grpc+json", "-H", "TE: trailers", "--http2-prior-knowledge"},
+		},
+		{
+			"https",
+			nil,
+			"https://svc.ns.svc:8443/pkg.Service/Method",
+			[]string{"-H", "Content-Type: application/grpc+json", "-H", "TE: trailers", "--http2"},
+		},
+		{
+			"user content type",
+			[]string{"-H", "content-type: application/grpc-web+json"},
+			"http://svc.ns.svc:8080/pkg.Service/Method",
+			[]string{"-H", "TE: trailers", "--http2-prior-knowledge"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := grpcArgs(tc.args, tc.serviceURL); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDataJSONArgs(t *testing.T) {
+	expected := []string{"-H", "Content-Type: application/json", "-H", "Accept: application/json", "--data-binary", `{"a":1}`}
+	if got := dataJSONArgs(nil, `{"a":1}`); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	// Headers the user set are left alone
+	expected = []string{"-H", "Content-Type: application/json", "--data-binary", `{"a":1}`}
+	if got := dataJSONArgs([]string{"-H", "accept: application/problem+json"}, `{"a":1}`); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestNewRequestID(t *testing.T) {
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	for range 100 {
+		id, err := newRequestID()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !uuidV4.MatchString(id) {
+			t.Errorf("Expected a version 4 UUID, got %s", id)
+		}
+		if seen[id] {
+			t.Errorf("Got %s twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestExtractKurlFlagsAllowNotReady(t *testing.T) {
+	// --insecure-port-forward is an alias
+	for _, flag := range []string{"--allow-not-ready", "--insecure-port-forward"} {
+		opts, curlArgs, err := extractKurlFlags([]string{flag, "http://svc"})
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", flag, err)
+		}
+		if !opts.resolve.allowNotReady || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+			t.Errorf("Unexpected result for %s: allowNotReady=%v args=%v", flag, opts.resolve.allowNotReady, curlArgs)
+		}
+	}
+}
+
+func TestExtractKurlFlagsStdin(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--stdin", "-s", "-H", "Accept: application/json"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !opts.stdin || !reflect.DeepEqual(curlArgs, []string{"-s", "-H", "Accept: application/json"}) {
+		t.Errorf("Unexpected result: stdin=%v args=%v", opts.stdin, curlArgs)
+	}
+
+	for _, flag := range []string{"--all-pods", "--forward-only", "--websocket"} {
+		if _, _, err := extractKurlFlags([]string{"--stdin", flag}); err == nil {
+			t.Errorf("Expected error for --stdin with %s, got nil", flag)
+		}
+	}
+}
+
+func TestExtractKurlFlagsLocalPort(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--local-port", "18080", "http://svc.ns.svc:8080/api"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.localPort != 18080 || !reflect.DeepEqual(curlArgs, []string{"http://svc.ns.svc:8080/api"}) {
+		t.Errorf("Unexpected result: localPort=%d args=%v", opts.localPort, curlArgs)
+	}
+	if got := reconstructURL(curlArgs[0], opts.localPort); got != "http://localhost:18080/api" {
+		t.Errorf("Expected the local port in the URL, got %s", got)
+	}
+
+	for _, port := range []string{"0", "65536", "http"} {
+		if _, _, err := extractKurlFlags([]string{"--local-port", port, "http://svc"}); err == nil {
+			t.Errorf("Expected error for --local-port %s, got nil", port)
+		}
+	}
+}
+
+func TestExtractKurlFlagsOutputTemplate(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--all-pods", "--output-template", "out/{{.Pod}}.json", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.outputTemplate == nil || !opts.needsBuiltinClient() || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Unexpected result: outputTemplate=%v args=%v", opts.outputTemplate, curlArgs)
+	}
+
+	for _, args := range [][]string{
+		{"--output-template", "{{.Pod}}", "http://svc"},
+		{"--all-pods", "--forward-only", "--output-template", "{{.Pod}}", "http://svc"},
+		{"--stdin", "--output-template", "{{.Pod"},
+	} {
+		if _, _, err := extractKurlFlags(args); err == nil {
+			t.Errorf("Expected error for %v, got nil", args)
+		}
+	}
+}
+
+func TestExtractKurlFlagsRate(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--stdin", "--rate", "2.5/s", "-s"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.rate != 2.5 || !reflect.DeepEqual(curlArgs, []string{"-s"}) {
+		t.Errorf("Unexpected result: rate=%v args=%v", opts.rate, curlArgs)
+	}
+	if limiter := newRateLimiter(opts); limiter == nil || limiter.Limit() != 2.5 {
+		t.Errorf("Expected a limiter of 2.5 requests per second, got %v", limiter)
+	}
+	if limiter := newRateLimiter(&kurlOptions{}); limiter != nil {
+		t.Errorf("Expected no limiter without --rate, got %v", limiter)
+	}
+
+	for _, args := range [][]string{
+		{"--rate", "10/s", "http://svc"},
+		{"--all-pods", "--rate", "10", "http://svc"},
+		{"--all-pods", "--rate", "0/s", "http://svc"},
+		{"--all-pods", "--rate", "NaN/s", "http://svc"},
+		{"--all-pods", "--rate", "ten/s", "http://svc"},
+	} {
+		if _, _, err := extractKurlFlags(args); err == nil {
+			t.Errorf("Expected error for %v, got nil", args)
+		}
+	}
+}
+
+func TestExtractKurlFlagsRepeat(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--repeat", "5", "--pipeline", "-s", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.repeat != 5 || !opts.pipeline || !opts.needsBuiltinClient() || !reflect.DeepEqual(curlArgs, []string{"-s", "http://svc"}) {
+		t.Errorf("Unexpected result: repeat=%d pipeline=%v args=%v", opts.repeat, opts.pipeline, curlArgs)
+	}
+
+	// --count is an alias of --repeat
+	opts, _, err = extractKurlFlags([]string{"--count=3", "http://svc"})
+	if err != nil || opts.repeat != 3 {
+		t.Errorf("Expected --count to set repeat to 3, got %d (err %v)", opts.repeat, err)
+	}
+
+	for _, args := range [][]string{
+		{"--repeat", "0", "http://svc"},
+		{"--repeat", "many", "http://svc"},
+		{"--repeat", "2", "--all-pods", "http://svc"},
+		{"--repeat", "2", "--stdin"},
+		{"--pipeline", "http://svc"},
+		{"--repeat", "2", "--pipeline", "--no-keepalive", "http://svc"},
+		{"--repeat", "2", "--count", "2", "http://svc"},
+		{"--count", "0", "http://svc"},
+	} {
+		if _, _, err := extractKurlFlags(args); err == nil {
+			t.Errorf("Expected error for %v, got nil", args)
+		}
+	}
+}
+
+func TestExtractKurlFlagsNullTerminated(t *testing.T) {
+	for _, args := range [][]string{
+		{"--all-pods", "--null-terminated", "http://svc"},
+		{"--all-pods", "-0", "http://svc"},
+		{"-0", "--repeat", "2", "http://svc"},
+		{"--repeat", "1", "-0", "http://svc"},
+	} {
+		opts, curlArgs, err := extractKurlFlags(args)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", args, err)
+		}
+		if !opts.nullTerminated || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+			t.Errorf("Unexpected result for %v: nullTerminated=%v args=%v", args, opts.nullTerminated, curlArgs)
+		}
+		// curl would not write the terminators
+		if !opts.needsBuiltinClient() {
+			t.Errorf("Expected %v to need the built-in client", args)
+		}
+	}
+
+	// Otherwise -0 is curl's --http1.0
+	opts, curlArgs, err := extractKurlFlags([]string{"-0", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.nullTerminated || !reflect.DeepEqual(curlArgs, []string{"-0", "http://svc"}) {
+		t.Errorf("Expected -0 to be passed to curl, got nullTerminated=%v args=%v", opts.nullTerminated, curlArgs)
+	}
+
+	for _, args := range [][]string{
+		{"--null-terminated", "http://svc"},
+		{"--null-terminated", "--stdin"},
+		{"--null-terminated", "--all-pods", "--forward-only", "http://svc"},
+	} {
+		if _, _, err := extractKurlFlags(args); err == nil {
+			t.Errorf("Expected error for %v, got nil", args)
+		}
+	}
+}
+
+func TestExtractKurlFlagsBufferSize(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--buffer-size", "65536", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.bufferSize != 65536 || !opts.needsBuiltinClient() || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Unexpected result: bufferSize=%d args=%v", opts.bufferSize, curlArgs)
+	}
+	for _, size := range []string{"0", "-1", "64k"} {
+		if _, _, err := extractKurlFlags([]string{"--buffer-size", size, "http://svc"}); err == nil {
+			t.Errorf("Expected error for --buffer-size %s, got nil", size)
+		}
+	}
+}
+
+func TestExtractKurlFlagsHexDump(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--hex-dump", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !opts.hexDump || !opts.needsBuiltinClient() || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Unexpected result: hexDump=%v args=%v", opts.hexDump, curlArgs)
+	}
+	for _, flag := range []string{"--sse", "--ndjson"} {
+		if _, _, err := extractKurlFlags([]string{"--hex-dump", flag, "http://svc"}); err == nil {
+			t.Errorf("Expected error for --hex-dump with %s, got nil", flag)
+		}
+	}
+}
+
+func TestExtractKurlFlagsBase64(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--base64", "--base64-decode", "-d", "e30=", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !opts.base64 || !opts.base64Decode || !opts.needsBuiltinClient() || !reflect.DeepEqual(curlArgs, []string{"-d", "e30=", "http://svc"}) {
+		t.Errorf("Unexpected result: base64=%v base64Decode=%v args=%v", opts.base64, opts.base64Decode, curlArgs)
+	}
+	for _, flag := range []string{"--hex-dump", "--sse", "--ndjson"} {
+		if _, _, err := extractKurlFlags([]string{"--base64", flag, "http://svc"}); err == nil {
+			t.Errorf("Expected error for --base64 with %s, got nil", flag)
+		}
+	}
+}
+
+func TestExtractKurlFlagsTruncate(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--truncate", "100", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.truncate != 100 || !opts.needsBuiltinClient() || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Unexpected result: truncate=%d args=%v", opts.truncate, curlArgs)
+	}
+	for _, size := range []string{"0", "-5", "1k"} {
+		if _, _, err := extractKurlFlags([]string{"--truncate", size, "http://svc"}); err == nil {
+			t.Errorf("Expected error for --truncate %s, got nil", size)
+		}
+	}
+}
+
+func TestExtractKurlFlagsColorScheme(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--color-scheme", "light", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.colorScheme != "light" || !opts.needsBuiltinClient() || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Unexpected result: colorScheme=%q args=%v", opts.colorScheme, curlArgs)
+	}
+	if _, _, err := extractKurlFlags([]string{"--color-scheme", "solarized", "http://svc"}); err == nil {
+		t.Errorf("Expected error for an unknown color scheme, got nil")
+	}
+}
+
+func TestExtractKurlFlagsRequestID(t *testing.T) {
+	opts, curlArgs, err := extractKurlFlags([]string{"--request-id", "--request-id-header", "X-Correlation-Id", "http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !opts.requestID || opts.requestIDHeader != "X-Correlation-Id" || !reflect.DeepEqual(curlArgs, []string{"http://svc"}) {
+		t.Errorf("Unexpected result: requestID=%v header=%q args=%v", opts.requestID, opts.requestIDHeader, curlArgs)
+	}
+
+	if _, _, err := extractKurlFlags([]string{"--request-id-header", "X-Correlation-Id", "http://svc"}); err == nil {
+		t.Errorf("Expected error for --request-id-header without --request-id, got nil")
+	}
+	if _, _, err := extractKurlFlags([]string{"--request-id", "--request-id-header", "X Correlation", "http://svc"}); err == nil {
+		t.Errorf("Expected error for an invalid header name, got nil")
+	}
+}
+
+func TestRunCustomHTTPDataJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Accept") != "application/json" {
+			t.Errorf("Expected JSON Content-Type and Accept headers, got %v", r.Header)
+		}
+		if string(body) != `{"a":1}` {
+			t.Errorf("Expected the JSON body, got %q", string(body))
+		}
+	}))
+	defer server.Close()
+
+	pod := &ForwardTarget{Name: "my-pod", Namespace: "default"}
+	if _, err := runCustomHTTP(context.Background(), dataJSONArgs(nil, `{"a":1}`), server.URL, false, pod, server.URL, 1, &kurlOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestRunCustomHTTPPostData(t *testing.T) {
+	var method, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		method, body = r.Method, string(content)
+	}))
+	defer server.Close()
+
+	opts, _, err := extractKurlFlags([]string{"--post-data=", "http://svc"})
+	if err != nil || opts.postData == nil || *opts.postData != "" {
+		t.Fatalf("Expected an empty --post-data, got %v (err %v)", opts.postData, err)
+	}
+
+	testCases := []struct {
+		name         string
+		args         []string
+		method, body string
+	}{
+		{"body", postDataArgs("a=1"), "POST", "a=1"},
+		{"empty body", postDataArgs(""), "POST", ""},
+		{"explicit method", append(postDataArgs("a=1"), "-X", "PUT"), "PUT", "a=1"},
+	}
+	pod := &ForwardTarget{Name: "my-pod", Namespace: "default"}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := runCustomHTTP(context.Background(), tc.args, server.URL, false, pod, server.URL, 1, &kurlOptions{}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if method != tc.method || body != tc.body {
+				t.Errorf("Expected %s %q, got %s %q", tc.method, tc.body, method, body)
+			}
+		})
+	}
+}
+
+func TestExtractKurlFlagsAssertHeader(t *testing.T) {
+	opts, _, err := extractKurlFlags([]string{"--assert-header", "Content-Type: application/json", "--assert-header=X-Request-Id:*"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []headerAssertion{{"Content-Type", "application/json"}, {"X-Request-Id", "*"}}
+	if !reflect.DeepEqual(opts.assertHeaders, expected) {
+		t.Errorf("Expected %v, got %v", expected, opts.assertHeaders)
+	}
+
+	for _, header := range []string{"Content-Type", ": value"} {
+		if _, _, err := extractKurlFlags([]string{"--assert-header", header}); err == nil {
+			t.Errorf("Expected error for --assert-header %q, got nil", header)
+		}
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	if err := runHook(context.Background(), `test "$KURL_TEST" = ok`, "KURL_TEST=ok"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// A failing hook's exit code becomes kurl's
+	err := runHook(context.Background(), "exit 3")
+	if err == nil {
+		t.Fatalf("Expected error for a failing hook, got nil")
+	}
+	if code := exitCode(err); code != 3 {
+		t.Errorf("Expected exit code 3, got %d", code)
+	}
+}
+
+func TestRunCustomHTTPUploadFile(t *testing.T) {
+	upload := filepath.Join(t.TempDir(), "upload.bin")
+	if err := os.WriteFile(upload, []byte("file\x00contents"), 0600); err != nil {
+		t.Fatalf("Failed to write upload file: %v", err)
+	}
+
+	var method, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		method, body = r.Method, string(content)
+	}))
+	defer server.Close()
+	pod := &ForwardTarget{Name: "my-pod", Namespace: "default"}
+
+	// -T makes the request a PUT, unless -X says otherwise
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-T", upload}, http.MethodPut},
+		{[]string{"-X", "POST", "--upload-file", upload}, http.MethodPost},
+	} {
+		if _, err := runCustomHTTP(context.Background(), tc.args, server.URL, false, pod, server.URL, 1, &kurlOptions{}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if method != tc.expected || body != "file\x00contents" {
+			t.Errorf("Expected a %s of the file with %v, got %s %q", tc.expected, tc.args, method, body)
+		}
+	}
+}
+
+func TestRunCustomHTTPBase64Decode(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	pod := &ForwardTarget{Name: "my-pod", Namespace: "default"}
+
+	// Line breaks, as base64 tools wrap their output with, are ignored
+	opts := &kurlOptions{base64Decode: true}
+	if _, err := runCustomHTTP(context.Background(), []string{"--data-binary", "AP9r\ndXJs\n"}, server.URL, false, pod, server.URL, 1, opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(body, []byte{0x00, 0xff, 'k', 'u', 'r', 'l'}) {
+		t.Errorf("Expected the decoded bytes, got %q", body)
+	}
+
+	if _, err := runCustomHTTP(context.Background(), []string{"-d", "not base64!"}, server.URL, false, pod, server.URL, 1, opts); err == nil {
+		t.Errorf("Expected an error for a body that is not base64, got nil")
+	}
+}
+
+func TestRunCustomHTTPPipeline(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	var connections atomic.Int32
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	pod := &ForwardTarget{Name: "my-pod", Namespace: "default"}
+
+	// -k gives each request a transport of its own, and so a connection of its own, unless --pipeline shares one
+	for _, pipeline := range []bool{false, true} {
+		connections.Store(0)
+		opts := &kurlOptions{repeat: 3, pipeline: pipeline}
+		for i := 1; i <= opts.repeat; i++ {
+			if _, err := runCustomHTTP(context.Background(), []string{"-k"}, server.URL, false, pod, server.URL, i, opts); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+
+		expected := int32(3)
+		if pipeline {
+			expected = 1
+		}
+		if got := connections.Load(); got != expected {
+			t.Errorf("Expected %d connections with pipeline=%v, got %d", expected, pipeline, got)
+		}
+	}
+}
+
+func TestRunCustomHTTPAfterRequest(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	pod := &ForwardTarget{Name: "my-pod", Namespace: "default"}
+
+	// The hook sees the outcome of the request
+	envFile := filepath.Join(t.TempDir(), "env")
+	opts := &kurlOptions{afterRequest: `echo "$KURL_STATUS_CODE $KURL_RESPONSE_BYTES $KURL_DURATION_MS" > ` + shellEscape(envFile)}
+	if _, err := runCustomHTTP(context.Background(), nil, server.URL, false, pod, server.URL, 1, opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	env, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("Failed to read hook output: %v", err)
+	}
+	fields := strings.Fields(string(env))
+	if len(fields) != 3 || fields[0] != "404" || fields[1] != "0" {
+		t.Errorf("Expected status 404, 0 bytes and a duration, got %q", string(env))
+	}
+
+	// A failing hook fails the request with its exit code
+	opts = &kurlOptions{afterRequest: "exit 4"}
+	_, err = runCustomHTTP(context.Background(), nil, server.URL, false, pod, server.URL, 1, opts)
+	if code := exitCode(err); code != 4 {
+		t.Errorf("Expected exit code 4 from the hook, got %d (%v)", code, err)
+	}
+}
+
+func TestExtractKurlFlagsPortForwardRetries(t *testing.T) {
+	opts, _, err := extractKurlFlags([]string{"http://svc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.kube.portForwardRetries != defaultPortForwardRetries {
+		t.Errorf("Expected %d port-forward retries, got %d", defaultPortForwardRetries, opts.kube.portForwardRetries)
+	}
+
+	for _, retry := range []string{"5", "0"} {
+		opts, curlArgs, err := extractKurlFlags([]string{"--retry", retry, "http://svc"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if fmt.Sprint(opts.kube.portForwardRetries) != retry {
+			t.Errorf("Expected %s port-forward retries, got %d", retry, opts.kube.portForwardRetries)
+		}
+		if !reflect.DeepEqual(curlArgs, []string{"--retry", retry, "http://svc"}) {
+			t.Errorf("Expected --retry to be left for curl, got %v", curlArgs)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jqFilter is the subset of jq that --jq supports: a path such as .items[0].name, which prints that value of each
+// object, or select(<path> == <value>) and select(<path> != <value>), which keep only the matching objects
+type jqFilter struct {
+	// path holds string keys and int indexes; an empty path is the object itself
+	path []any
+
+	// op is "==" or "!=" for select() and empty for a plain path
+	op    string
+	value any
+}
+
+// parseJQFilter parses a --jq filter
+func parseJQFilter(s string) (*jqFilter, error) {
+	s = strings.TrimSpace(s)
+
+	inner, isSelect := strings.CutPrefix(s, "select(")
+	if !isSelect {
+		path, err := parseJQPath(s)
+		if err != nil {
+			return nil, err
+		}
+		return &jqFilter{path: path}, nil
+	}
+
+	inner, closed := strings.CutSuffix(inner, ")")
+	if !closed {
+		return nil, fmt.Errorf("missing ) in %s", s)
+	}
+	op := "=="
+	i := strings.Index(inner, op)
+	if j := strings.Index(inner, "!="); j >= 0 && (i < 0 || j < i) {
+		op, i = "!=", j
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("expected select(<path> == <value>) or select(<path> != <value>), got %s", s)
+	}
+
+	path, err := parseJQPath(strings.TrimSpace(inner[:i]))
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(inner[i+len(op):])), &value); err != nil {
+		return nil, fmt.Errorf("invalid value in %s: %v", s, err)
+	}
+	return &jqFilter{path: path, op: op, value: value}, nil
+}
+
+// parseJQPath parses a path of .key, ["key"] and [index] steps, like .items[0].metadata["name"]
+func parseJQPath(s string) ([]any, error) {
+	if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("path %q must start with .", s)
+	}
+
+	var path []any
+	rest := s[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in path %q", s)
+			}
+			step := rest[1:end]
+			if key, err := strconv.Unquote(step); err == nil {
+				path = append(path, key)
+			} else if index, err := strconv.Atoi(step); err == nil {
+				path = append(path, index)
+			} else {
+				return nil, fmt.Errorf("invalid step [%s] in path %q", step, s)
+			}
+			rest = rest[end+1:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if strings.ContainsAny(key, " ()|,") {
+				return nil, fmt.Errorf("unsupported filter %q: only paths and select() are supported", s)
+			}
+			path = append(path, key)
+			rest = rest[end:]
+		}
+	}
+	return path, nil
+}
+
+// apply runs the filter on one JSON value, returning what to print and whether to print anything. Values are
+// passed on as raw JSON so that their key order is kept.
+func (f *jqFilter) apply(raw json.RawMessage) (json.RawMessage, bool, error) {
+	found := raw
+	for _, step := range f.path {
+		if found == nil || bytes.Equal(found, []byte("null")) {
+			found = nil
+			break
+		}
+		switch step := step.(type) {
+		case string:
+			var object map[string]json.RawMessage
+			if err := json.Unmarshal(found, &object); err != nil {
+				return nil, false, fmt.Errorf("cannot index %s with %q", jsonKind(found), step)
+			}
+			found = object[step]
+		case int:
+			var array []json.RawMessage
+			if err := json.Unmarshal(found, &array); err != nil {
+				return nil, false, fmt.Errorf("cannot index %s with %d", jsonKind(found), step)
+			}
+			if step < 0 {
+				step += len(array)
+			}
+			found = nil
+			if step >= 0 && step < len(array) {
+				found = array[step]
+			}
+		}
+	}
+	if found == nil {
+		found = json.RawMessage("null")
+	}
+
+	if f.op == "" {
+		return found, true, nil
+	}
+	var value any
+	if err := json.Unmarshal(found, &value); err != nil {
+		return nil, false, err
+	}
+	return raw, reflect.DeepEqual(value, f.value) == (f.op == "=="), nil
+}
+
+// jsonKind names the type of a JSON value for error messages
+func jsonKind(raw json.RawMessage) string {
+	switch bytes.TrimSpace(raw)[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	default:
+		return "scalar"
+	}
+}
+
+// writeNDJSON reads newline-delimited JSON from r and writes each value to w on its own line as soon as it is
+// complete, indented with pretty and passed through filter when not nil
+func writeNDJSON(w io.Writer, r io.Reader, pretty bool, filter *jqFilter) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return fmt.Errorf("invalid JSON on line %d", lineNumber)
+		}
+
+		value := json.RawMessage(line)
+		if filter != nil {
+			var keep bool
+			var err error
+			if value, keep, err = filter.apply(value); err != nil {
+				return fmt.Errorf("--jq failed on line %d: %v", lineNumber, err)
+			}
+			if !keep {
+				continue
+			}
+		}
+
+		var out bytes.Buffer
+		if pretty {
+			json.Indent(&out, value, "", "  ")
+		} else {
+			json.Compact(&out, value)
+		}
+		out.WriteByte('\n')
+		if _, err := w.Write(out.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWriteNDJSON(t *testing.T) {
+	stream := `{"type":"ADDED","object":{"metadata":{"name":"a"},"spec":{"ports":[80,443]}}}` + "\n" +
+		"\n" +
+		`{"type": "DELETED", "object": {"metadata": {"name": "b"}}}` + "\r\n"
+
+	testCases := []struct {
+		name     string
+		pretty   bool
+		filter   string
+		expected string
+	}{
+		{
+			name: "compact",
+			expected: `{"type":"ADDED","object":{"metadata":{"name":"a"},"spec":{"ports":[80,443]}}}` + "\n" +
+				`{"type":"DELETED","object":{"metadata":{"name":"b"}}}` + "\n",
+		},
+		{
+			name:     "pretty",
+			pretty:   true,
+			filter:   ".object.metadata",
+			expected: "{\n  \"name\": \"a\"\n}\n{\n  \"name\": \"b\"\n}\n",
+		},
+		{
+			name:     "path",
+			filter:   ".object.spec.ports[-1]",
+			expected: "443\nnull\n",
+		},
+		{
+			name:     "select",
+			filter:   `select(.type == "DELETED")`,
+			expected: `{"type":"DELETED","object":{"metadata":{"name":"b"}}}` + "\n",
+		},
+		{
+			name:     "select not equal",
+			filter:   `select(.object.metadata["name"] != "b")`,
+			expected: `{"type":"ADDED","object":{"metadata":{"name":"a"},"spec":{"ports":[80,443]}}}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var filter *jqFilter
+			if tc.filter != "" {
+				var err error
+				if filter, err = parseJQFilter(tc.filter); err != nil {
+					t.Fatalf("Unexpected error parsing %q: %v", tc.filter, err)
+				}
+			}
+
+			// Deliver the stream one byte at a time so values are split across reads
+			var out bytes.Buffer
+			if err := writeNDJSON(&out, iotest.OneByteReader(strings.NewReader(stream)), tc.pretty, filter); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestWriteNDJSONInvalid(t *testing.T) {
+	var out bytes.Buffer
+	err := writeNDJSON(&out, strings.NewReader("{\"ok\":true}\nnot json\n"), false, nil)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error for line 2, got %v", err)
+	}
+	if out.String() != "{\"ok\":true}\n" {
+		t.Errorf("Expected the valid line before the error, got %q", out.String())
+	}
+}
+
+func TestParseJQFilter(t *testing.T) {
+	valid := []string{".", ".a", ".a.b[0]", `.a["b c"]`, `select(.a == 1)`, `select(.a.b != "x")`}
+	for _, filter := range valid {
+		if _, err := parseJQFilter(filter); err != nil {
+			t.Errorf("Unexpected error for %q: %v", filter, err)
+		}
+	}
+
+	invalid := []string{"a", ".a[", ".a[x]", "select(.a)", "select(.a == 1", "select(.a == x)", ".a | .b", "map(.a)"}
+	for _, filter := range invalid {
+		if _, err := parseJQFilter(filter); err == nil {
+			t.Errorf("Expected error for %q, got nil", filter)
+		}
+	}
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// kurlOptions holds the flags that kurl consumes itself; they are never passed on to curl
+type kurlOptions struct {
+	kube    kubeOptions
+	resolve resolveOptions
+
+	// namespaceAll searches every namespace for a service given without one in the URL
+	namespaceAll bool
+
+	// exec is a shell command run against the port-forward instead of curl
+	exec string
+
+	// forwardOnly keeps the port-forward open until interrupted instead of making a request, saved as a session
+	// under label when set
+	forwardOnly bool
+	label       string
+
+	// allPods forwards to every pod behind the resource instead of the first one, and multipleInterface
+	// gives each pod its own loopback address with the same port instead of its own port
+	allPods           bool
+	multipleInterface bool
+
+	// showPod prints the pod the request goes to on stderr
+	showPod bool
+
+	// outputFormat "json" prints the response as a JSON envelope with its status and headers
+	outputFormat string
+
+	// formatResponse, when set, prints the response through this template instead of as is
+	formatResponse *template.Template
+
+	// outputTemplate, when set, writes the response of each --stdin or --all-pods request to the file it names
+	outputTemplate *template.Template
+
+	// formType forces the encoding of -F values to "multipart" or "urlencoded"
+	formType string
+
+	// tee also writes the response to this file
+	tee string
+
+	// statusExitCodes maps response status codes to the exit code kurl ends with when it gets them
+	statusExitCodes map[int]int
+
+	// grpc sends the request with gRPC-JSON transcoding headers over HTTP/2 and prints the response trailers
+	grpc bool
+
+	// websocket opens a WebSocket instead of making a request, piping stdin and stdout through it
+	websocket bool
+
+	// sse reads the response as a Server-Sent Events stream and prints the data of each event, or only of the
+	// events of type sseEvent when set
+	sse      bool
+	sseEvent string
+
+	// assertStatus fails the request unless the response status is one of these
+	assertStatus []int
+
+	// assertBodyContains fails the request unless the response body contains this string
+	assertBodyContains string
+
+	// assertHeaders fails the request unless the response has all of these headers
+	assertHeaders []headerAssertion
+
+	// beforeForward is a shell command run before the port-forward is set up; kurl stops if it fails
+	beforeForward string
+
+	// afterRequest is a shell command run once the response is received, told about it via the environment
+	afterRequest string
+
+	// dataJSON is sent as the request body with JSON Content-Type and Accept headers
+	dataJSON string
+
+	// postData, when set, is sent as the request body of a POST, even an empty one
+	postData *string
+
+	// stdin reads the URLs to request from stdin, one per line, instead of taking one from the arguments
+	stdin bool
+
+	// localPort is the local port to forward from; 0 picks a free one
+	localPort int
+
+	// repeat sends the request this many times over the same port-forward; pipeline sends them all over one
+	// connection
+	repeat   int
+	pipeline bool
+
+	// transport is the transport the --pipeline requests share, made for the first of them
+	transport http.RoundTripper
+
+	// nullTerminated ends each --repeat or --all-pods response with a NUL byte, for xargs -0
+	nullTerminated bool
+
+	// hexDump prints the response body as a hex dump, like hexdump -C
+	hexDump bool
+
+	// base64 prints the response body encoded as base64; base64Decode sends the -d body decoded from base64
+	base64       bool
+	base64Decode bool
+
+	// truncate prints only the first truncate bytes of the response body; zero prints all of it
+	truncate int64
+
+	// bufferSize is the size of the buffer the built-in client copies the body through; zero means the default
+	bufferSize int
+
+	// rate caps how many --stdin or --all-pods requests are sent per second; zero means no limit
+	rate rate.Limit
+
+	// colorScheme is the palette the built-in client colors terminal output with, "dark" or "light"
+	colorScheme string
+
+	// requestID sends a random UUID in the requestIDHeader header, X-Request-Id when empty
+	requestID       bool
+	requestIDHeader string
+
+	// ndjson reads the response as newline-delimited JSON, printing each value as it arrives, indented with jsonPP
+	// and passed through the jq filter when set
+	ndjson bool
+	jsonPP bool
+	jq     *jqFilter
+}
+
+// needsBuiltinClient reports whether the options ask for output only the built-in HTTP client can produce,
+// in which case it is used even when curl is available
+func (opts *kurlOptions) needsBuiltinClient() bool {
+	return opts.outputFormat != "" || opts.formatResponse != nil || opts.formType != "" || opts.tee != "" ||
+		opts.statusExitCodes != nil || opts.sse || opts.ndjson || opts.assertStatus != nil ||
+		opts.assertBodyContains != "" || opts.assertHeaders != nil || opts.afterRequest != "" || opts.colorScheme != "" ||
+		opts.outputTemplate != nil || opts.repeat > 1 || opts.pipeline ||
+		opts.bufferSize != 0 || opts.truncate != 0 || opts.hexDump ||
+		opts.base64 || opts.base64Decode || opts.nullTerminated
+}
+
+// extractKurlFlags removes kurl's own flags from args, returning them parsed alongside the remaining curl arguments
+func extractKurlFlags(args []string) (*kurlOptions, []string, error) {
+	opts := &kurlOptions{kube: kubeOptions{apiTimeout: defaultAPITimeout}}
+	var curlArgs []string
+	// repeatFlag is whichever of --repeat and --count was given
+	var repeatFlag string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		// flagValue returns the value of the current flag, given either as --flag=value or --flag value
+		flagValue := func() (string, error) {
+			if hasValue {
+				return value, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("flag %s requires a value", name)
+			}
+			i++
+			return args[i], nil
+		}
+
+		// boolValue returns the value of the current boolean flag, which may be given as --flag or --flag=true|false
+		boolValue := func() (bool, error) {
+			if !hasValue {
+				return true, nil
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, fmt.Errorf("invalid value %q for flag %s", value, name)
+			}
+			return b, nil
+		}
+
+		var err error
+		switch name {
+		case "--pod-label-selector":
+			var selector string
+			if selector, err = flagValue(); err == nil {
+				opts.resolve.podSelector, err = labels.Parse(selector)
+				if err != nil {
+					err = fmt.Errorf("invalid --pod-label-selector %q: %v", selector, err)
+				}
+			}
+		case "--selector":
+			if opts.resolve.selector, err = flagValue(); err == nil {
+				if _, err = labels.Parse(opts.resolve.selector); err != nil {
+					err = fmt.Errorf("invalid --selector %q: %v", opts.resolve.selector, err)
+				}
+			}
+		case "--kubeconfig":
+			opts.kube.kubeconfig, err = flagValue()
+		case "--context":
+			opts.kube.context, err = flagValue()
+		case "--impersonate":
+			opts.kube.impersonate, err = flagValue()
+		case "--impersonate-group":
+			var group string
+			if group, err = flagValue(); err == nil {
+				opts.kube.impersonateGroups = append(opts.kube.impersonateGroups, group)
+			}
+		case "--service-account":
+			opts.kube.serviceAccount, err = flagValue()
+		case "--namespace":
+			opts.kube.serviceAccountNamespace, err = flagValue()
+		case "--exec":
+			opts.exec, err = flagValue()
+		case "--forward-only":
+			opts.forwardOnly, err = boolValue()
+		case "--label":
+			if opts.label, err = flagValue(); err == nil {
+				err = validateSessionLabel(opts.label)
+			}
+		case "--all-pods":
+			opts.allPods, err = boolValue()
+		case "--multiple-interface":
+			opts.multipleInterface, err = boolValue()
+		case "--no-resolve":
+			opts.resolve.noResolve, err = boolValue()
+		case "--k8s-timeout":
+			var seconds string
+			if seconds, err = flagValue(); err == nil {
+				opts.kube.apiTimeout, err = parseSeconds(name, seconds)
+			}
+		case "--output-format":
+			if opts.outputFormat, err = flagValue(); err == nil && opts.outputFormat != "json" {
+				err = fmt.Errorf("unsupported --output-format %q: only json is supported", opts.outputFormat)
+			}
+		case "--format-response":
+			var text string
+			if text, err = flagValue(); err == nil {
+				opts.formatResponse, err = template.New("format-response").Parse(text)
+				if err != nil {
+					err = fmt.Errorf("invalid --format-response template: %v", err)
+				}
+			}
+		case "--output-template":
+			var text string
+			if text, err = flagValue(); err == nil {
+				opts.outputTemplate, err = template.New("output-template").Parse(text)
+				if err != nil {
+					err = fmt.Errorf("invalid --output-template template: %v", err)
+				}
+			}
+		case "--form-type":
+			if opts.formType, err = flagValue(); err == nil && opts.formType != "multipart" && opts.formType != "urlencoded" {
+				err = fmt.Errorf("invalid --form-type %q: expected multipart or urlencoded", opts.formType)
+			}
+		case "--tee":
+			opts.tee, err = flagValue()
+		case "--response-code-to-exit":
+			var mapping string
+			if mapping, err = flagValue(); err == nil {
+				opts.statusExitCodes, err = parseStatusExitCodes(mapping)
+			}
+		case "--grpc":
+			opts.grpc, err = boolValue()
+		case "--websocket", "--ws":
+			opts.websocket, err = boolValue()
+		case "--sse":
+			opts.sse, err = boolValue()
+		case "--sse-event":
+			opts.sseEvent, err = flagValue()
+		case "--ndjson":
+			opts.ndjson, err = boolValue()
+		case "--json-pp":
+			opts.jsonPP, err = boolValue()
+		case "--jq":
+			var filter string
+			if filter, err = flagValue(); err == nil {
+				opts.jq, err = parseJQFilter(filter)
+				if err != nil {
+					err = fmt.Errorf("invalid --jq filter: %v", err)
+				}
+			}
+		case "--allow-not-ready", "--insecure-port-forward":
+			opts.resolve.allowNotReady, err = boolValue()
+		case "--data-json":
+			opts.dataJSON, err = flagValue()
+		case "--post-data":
+			var body string
+			if body, err = flagValue(); err == nil {
+				opts.postData = &body
+			}
+		case "--stdin":
+			opts.stdin, err = boolValue()
+		case "--local-port":
+			var port string
+			if port, err = flagValue(); err == nil {
+				if opts.localPort, err = strconv.Atoi(port); err != nil || opts.localPort < 1 || opts.localPort > 65535 {
+					err = fmt.Errorf("invalid --local-port %q: expected a port between 1 and 65535", port)
+				}
+			}
+		case "--repeat", "--count":
+			// --count is a hidden alias for --repeat
+			if repeatFlag != "" && repeatFlag != name {
+				err = fmt.Errorf("--repeat and --count cannot be used together")
+				break
+			}
+			repeatFlag = name
+			var count string
+			if count, err = flagValue(); err == nil {
+				if opts.repeat, err = strconv.Atoi(count); err != nil || opts.repeat < 1 {
+					err = fmt.Errorf("invalid %s %q: expected a positive number of requests", name, count)
+				}
+			}
+		case "--pipeline":
+			opts.pipeline, err = boolValue()
+		case "--null-terminated":
+			opts.nullTerminated, err = boolValue()
+		case "--hex-dump":
+			opts.hexDump, err = boolValue()
+		case "--base64":
+			opts.base64, err = boolValue()
+		case "--base64-decode":
+			opts.base64Decode, err = boolValue()
+		case "--truncate":
+			var size string
+			if size, err = flagValue(); err == nil {
+				if opts.truncate, err = strconv.ParseInt(size, 10, 64); err != nil || opts.truncate < 1 {
+					err = fmt.Errorf("invalid --truncate %q: expected a positive number of bytes", size)
+				}
+			}
+		case "--buffer-size":
+			var size string
+			if size, err = flagValue(); err == nil {
+				if opts.bufferSize, err = strconv.Atoi(size); err != nil || opts.bufferSize < 1 {
+					err = fmt.Errorf("invalid --buffer-size %q: expected a positive number of bytes", size)
+				}
+			}
+		case "--rate":
+			var value string
+			if value, err = flagValue(); err == nil {
+				opts.rate, err = parseRate(value)
+			}
+		case "--color-scheme":
+			if opts.colorScheme, err = flagValue(); err == nil && colorSchemes[opts.colorScheme] == nil {
+				err = fmt.Errorf("unsupported --color-scheme %q: use light or dark", opts.colorScheme)
+			}
+		case "--request-id":
+			opts.requestID, err = boolValue()
+		case "--request-id-header":
+			if opts.requestIDHeader, err = flagValue(); err == nil && !httpguts.ValidHeaderFieldName(opts.requestIDHeader) {
+				err = fmt.Errorf("invalid --request-id-header %q", opts.requestIDHeader)
+			}
+		case "--assert-status":
+			var statuses string
+			if statuses, err = flagValue(); err == nil {
+				opts.assertStatus, err = parseStatusList(statuses)
+			}
+		case "--assert-body-contains":
+			opts.assertBodyContains, err = flagValue()
+		case "--assert-header":
+			var header string
+			if header, err = flagValue(); err == nil {
+				name, pattern, found := strings.Cut(header, ":")
+				if !found || strings.TrimSpace(name) == "" {
+					err = fmt.Errorf("invalid --assert-header %q: expected <name>: <value>", header)
+				} else {
+					opts.assertHeaders = append(opts.assertHeaders, headerAssertion{
+						name:    strings.TrimSpace(name),
+						pattern: strings.TrimSpace(pattern),
+					})
+				}
+			}
+		case "--before-forward":
+			opts.beforeForward, err = flagValue()
+		case "--after-request":
+			opts.afterRequest, err = flagValue()
+		case "--debug":
+			opts.kube.debug, err = boolValue()
+		case "--trace-port-forward":
+			var trace bool
+			if trace, err = boolValue(); err == nil && trace {
+				opts.kube.traffic = &portForwardTraffic{}
+			}
+		case "--show-pod":
+			opts.showPod, err = boolValue()
+		case "--namespace-all":
+			opts.namespaceAll, err = boolValue()
+		default:
+			curlArgs = append(curlArgs, arg)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The API server cannot impersonate groups without a user to attach them to
+	if len(opts.kube.impersonateGroups) > 0 && opts.kube.impersonate == "" {
+		return nil, nil, fmt.Errorf("--impersonate-group requires --impersonate")
+	}
+
+	if opts.requestIDHeader != "" && !opts.requestID {
+		return nil, nil, fmt.Errorf("--request-id-header requires --request-id")
+	}
+
+	if opts.stdin && (opts.allPods || opts.forwardOnly || opts.exec != "" || opts.websocket) {
+		return nil, nil, fmt.Errorf("--stdin cannot be combined with --all-pods, --forward-only, --exec or --websocket")
+	}
+
+	if opts.forwardOnly && opts.exec != "" {
+		return nil, nil, fmt.Errorf("--forward-only and --exec cannot be used together")
+	}
+
+	if opts.label != "" && (!opts.forwardOnly || opts.allPods) {
+		return nil, nil, fmt.Errorf("--label requires --forward-only and cannot be used with --all-pods")
+	}
+
+	if opts.multipleInterface && !opts.allPods {
+		return nil, nil, fmt.Errorf("--multiple-interface requires --all-pods")
+	}
+	if opts.outputFormat != "" && opts.formatResponse != nil {
+		return nil, nil, fmt.Errorf("--output-format and --format-response cannot be used together")
+	}
+	if opts.resolve.noResolve && (opts.namespaceAll || opts.resolve.podSelector != nil) {
+		return nil, nil, fmt.Errorf("--no-resolve cannot be combined with --namespace-all or --pod-label-selector")
+	}
+	if opts.resolve.selector != "" && (opts.resolve.noResolve || opts.allPods) {
+		return nil, nil, fmt.Errorf("--selector cannot be combined with --no-resolve or --all-pods")
+	}
+	if opts.allPods && opts.exec != "" {
+		return nil, nil, fmt.Errorf("--all-pods and --exec cannot be used together")
+	}
+	if opts.sseEvent != "" && !opts.sse {
+		return nil, nil, fmt.Errorf("--sse-event requires --sse")
+	}
+	if (opts.jsonPP || opts.jq != nil) && !opts.ndjson {
+		return nil, nil, fmt.Errorf("--json-pp and --jq require --ndjson")
+	}
+	if opts.ndjson && opts.sse {
+		return nil, nil, fmt.Errorf("--ndjson and --sse cannot be used together")
+	}
+	if opts.websocket && (opts.exec != "" || opts.forwardOnly || opts.allPods) {
+		return nil, nil, fmt.Errorf("--websocket cannot be combined with --exec, --forward-only or --all-pods")
+	}
+
+	if opts.outputTemplate != nil && (!(opts.stdin || opts.allPods) || opts.forwardOnly) {
+		return nil, nil, fmt.Errorf("--output-template requires --stdin or --all-pods and cannot be used with --forward-only")
+	}
+
+	if (opts.hexDump || opts.base64) && (opts.sse || opts.ndjson || opts.outputFormat != "" || opts.formatResponse != nil) {
+		return nil, nil, fmt.Errorf("--hex-dump and --base64 cannot be combined with --sse, --ndjson, --output-format or --format-response")
+	}
+	if opts.hexDump && opts.base64 {
+		return nil, nil, fmt.Errorf("--hex-dump and --base64 cannot be used together")
+	}
+	if opts.repeat != 0 && (opts.stdin || opts.allPods || opts.forwardOnly || opts.exec != "" || opts.websocket) {
+		return nil, nil, fmt.Errorf("--repeat cannot be combined with --stdin, --all-pods, --forward-only, --exec or --websocket")
+	}
+	if opts.pipeline && opts.repeat == 0 {
+		return nil, nil, fmt.Errorf("--pipeline requires --repeat")
+	}
+	if opts.pipeline && (extractDisableKeepalives(curlArgs) || slices.Contains(curlArgs, "--ignore-content-length")) {
+		return nil, nil, fmt.Errorf("--pipeline cannot be used with --no-keepalive or --ignore-content-length")
+	}
+	// -0 is curl's --http1.0 unless there are several responses for it to separate
+	if (opts.repeat != 0 || opts.allPods) && !opts.forwardOnly && slices.Contains(curlArgs, "-0") {
+		curlArgs = slices.DeleteFunc(curlArgs, func(arg string) bool { return arg == "-0" })
+		opts.nullTerminated = true
+	}
+	if opts.nullTerminated && (!(opts.repeat != 0 || opts.allPods) || opts.forwardOnly) {
+		return nil, nil, fmt.Errorf("--null-terminated requires --repeat or --all-pods and cannot be used with --forward-only")
+	}
+	if opts.rate != 0 && (!(opts.stdin || opts.allPods) || opts.forwardOnly) {
+		return nil, nil, fmt.Errorf("--rate requires --stdin or --all-pods and cannot be used with --forward-only")
+	}
+
+	// curl's --retry also sets how often a failed port-forward is retried
+	opts.kube.portForwardRetries = defaultPortForwardRetries
+	if containsFlag(curlArgs, "--retry", "--retry") {
+		opts.kube.portForwardRetries, _ = extractRetry(curlArgs)
+	}
+
+	// --namespace only says where to find the service account; the target namespace always comes from the URL
+	if opts.kube.serviceAccountNamespace != "" && opts.kube.serviceAccount == "" {
+		return nil, nil, fmt.Errorf("--namespace requires --service-account")
+	}
+
+	return opts, curlArgs, nil
+}
+
+// parseSeconds parses a flag value given in (possibly fractional) seconds, like curl's --max-time
+func parseSeconds(name, value string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid value %q for flag %s: expected a number of seconds", value, name)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parseStatusExitCodes parses a --response-code-to-exit mapping such as "404=5,503=6"
+func parseStatusExitCodes(value string) (map[int]int, error) {
+	codes := make(map[int]int)
+	for _, pair := range strings.Split(value, ",") {
+		status, code, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid --response-code-to-exit %q: expected <status>=<exit code>", pair)
+		}
+		statusCode, err := strconv.Atoi(status)
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("invalid --response-code-to-exit %q: status must be between 100 and 599", pair)
+		}
+		exitCode, err := strconv.Atoi(code)
+		if err != nil || exitCode < 1 || exitCode > 255 {
+			return nil, fmt.Errorf("invalid --response-code-to-exit %q: exit code must be between 1 and 255", pair)
+		}
+		codes[statusCode] = exitCode
+	}
+	return codes, nil
+}
+
+// parseStatusList parses an --assert-status list such as "200,201,204"
+func parseStatusList(value string) ([]int, error) {
+	var statuses []int
+	for _, status := range strings.Split(value, ",") {
+		statusCode, err := strconv.Atoi(strings.TrimSpace(status))
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			return nil, fmt.Errorf("invalid --assert-status %q: status must be between 100 and 599", status)
+		}
+		statuses = append(statuses, statusCode)
+	}
+	return statuses, nil
+}
+
+// expandCurlConfig replaces each -K/--config <file> in args with the options read from that curl config file.
+// The options are moved in front of the command line so that, with curl's last-one-wins rule, the command line
+// takes precedence. A file of "-" is read from stdin.
+func expandCurlConfig(args []string) ([]string, error) {
+	var configArgs, rest []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, path, hasValue := strings.Cut(arg, "=")
+		if name != "-K" && name != "--config" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag %s requires a value", name)
+			}
+			i++
+			path = args[i]
+		}
+
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read config file: %v", err)
+			}
+			defer file.Close()
+			r = file
+		}
+		parsed, err := parseCurlConfig(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+		configArgs = append(configArgs, parsed...)
+	}
+
+	return append(configArgs, rest...), nil
+}
+
+// parseCurlConfig turns the lines of a curl config file into command-line arguments. Each line holds one option,
+// with or without its leading dashes, optionally followed by a value after whitespace, '=' or ':'. Values containing
+// whitespace are double-quoted, and lines starting with # are comments.
+func parseCurlConfig(r io.Reader) ([]string, error) {
+	var args []string
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Split the option from its value
+		name, rest := line, ""
+		if i := strings.IndexAny(line, " \t=:"); i >= 0 {
+			name, rest = line[:i], strings.TrimLeft(line[i:], " \t")
+			if strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ":") {
+				rest = strings.TrimLeft(rest[1:], " \t")
+			}
+		}
+		if !strings.HasPrefix(name, "-") {
+			name = "--" + name
+		}
+
+		value, err := curlConfigValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+
+		if rest == "" {
+			args = append(args, name)
+		} else {
+			args = append(args, name, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+// curlConfigValue unquotes a value from a curl config file. Unquoted values end at the first whitespace.
+func curlConfigValue(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		value, _, _ := strings.Cut(s, " ")
+		value, _, _ = strings.Cut(value, "\t")
+		return value, nil
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return value.String(), nil
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 't':
+					value.WriteByte('\t')
+				case 'n':
+					value.WriteByte('\n')
+				case 'r':
+					value.WriteByte('\r')
+				case 'v':
+					value.WriteByte('\v')
+				default:
+					value.WriteByte(s[i])
+				}
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted value %s", s)
+}
+
+// parseRate parses a --rate value like 10/s, the number of requests per second, which may be a fraction
+func parseRate(s string) (rate.Limit, error) {
+	perSecond, ok := strings.CutSuffix(s, "/s")
+	if !ok {
+		return 0, fmt.Errorf("invalid --rate %q: expected <requests>/s, like 10/s", s)
+	}
+	n, err := strconv.ParseFloat(perSecond, 64)
+	if err != nil || !(n > 0) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid --rate %q: expected a positive number of requests per second", s)
+	}
+	return rate.Limit(n), nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// savedSession describes a --forward-only port-forward started with --label, saved under ~/.kurl/sessions so that
+// later kurl invocations can find and stop it
+type savedSession struct {
+	Label string `json:"label"`
+	PID   int    `json:"pid"`
+	// Target is the URL given to kurl and Pod the namespace/name of the pod it was forwarded to
+	Target string `json:"target"`
+	Pod    string `json:"pod"`
+	// LocalPort and URL are where the port-forward listens
+	LocalPort int       `json:"local_port"`
+	URL       string    `json:"url"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// sessionLabelRegex keeps labels usable as file names
+var sessionLabelRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// validateSessionLabel checks that label can name a session file
+func validateSessionLabel(label string) error {
+	if !sessionLabelRegex.MatchString(label) {
+		return fmt.Errorf("invalid label %q: use letters, digits, '.', '_' and '-'", label)
+	}
+	return nil
+}
+
+// sessionsDir returns the directory session files are kept in
+func sessionsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %v", err)
+	}
+	return filepath.Join(home, ".kurl", "sessions"), nil
+}
+
+// sessionPath returns the file the session labeled label is saved in
+func sessionPath(label string) (string, error) {
+	if err := validateSessionLabel(label); err != nil {
+		return "", err
+	}
+	dir, err := sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, label+".json"), nil
+}
+
+// saveSession writes the session file, refusing to replace the file of a session that is still running
+func saveSession(s savedSession) error {
+	path, err := sessionPath(s.Label)
+	if err != nil {
+		return err
+	}
+	if existing, err := loadSession(s.Label); err == nil && processAlive(existing.PID) {
+		return fmt.Errorf("session %s is already running with pid %d", s.Label, existing.PID)
+	}
+
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create sessions directory: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return fmt.Errorf("failed to write session file: %v", err)
+	}
+	return nil
+}
+
+// loadSession reads the file of the session labeled label
+func loadSession(label string) (*savedSession, error) {
+	path, err := sessionPath(label)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s savedSession
+	if err := json.Unmarshal(content, &s); err != nil {
+		return nil, fmt.Errorf("invalid session file %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// removeSession deletes the file of the session labeled label, if any
+func removeSession(label string) error {
+	path, err := sessionPath(label)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// listSessions returns the sessions whose kurl process is still running, oldest first, and removes the files of
+// sessions whose process is gone
+func listSessions() ([]savedSession, error) {
+	dir, err := sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sessions directory: %v", err)
+	}
+
+	var sessions []savedSession
+	for _, entry := range entries {
+		label, isJSON := strings.CutSuffix(entry.Name(), ".json")
+		if !isJSON || entry.IsDir() || validateSessionLabel(label) != nil {
+			continue
+		}
+		s, err := loadSession(label)
+		if err != nil {
+			return nil, err
+		}
+		if !processAlive(s.PID) {
+			if err := removeSession(label); err != nil {
+				return nil, fmt.Errorf("failed to remove stale session %s: %v", label, err)
+			}
+			continue
+		}
+		sessions = append(sessions, *s)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions, nil
+}
+
+// printSessions writes a table of sessions to w with how long each has been running at now
+func printSessions(w io.Writer, sessions []savedSession, now time.Time) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "LABEL\tTARGET\tLOCAL PORT\tDURATION")
+	for _, s := range sessions {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\n", s.Label, s.Target, s.LocalPort, now.Sub(s.StartedAt).Round(time.Second))
+	}
+	return table.Flush()
+}
+
+// closeSessionTimeout is how long closeSession waits for the session's process to exit
+var closeSessionTimeout = 5 * time.Second
+
+// closeSession asks the kurl process behind the session labeled label to stop its port-forward, waits for it to
+// exit and removes the session file
+func closeSession(label string) error {
+	s, err := loadSession(label)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no session labeled %s", label)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A process that is already gone left a stale file behind; there is nothing to stop
+	if processAlive(s.PID) {
+		process, err := os.FindProcess(s.PID)
+		if err == nil {
+			err = process.Signal(syscall.SIGTERM)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stop session %s (pid %d): %v", label, s.PID, err)
+		}
+
+		deadline := time.Now().Add(closeSessionTimeout)
+		for processAlive(s.PID) {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("session %s (pid %d) did not exit within %v", label, s.PID, closeSessionTimeout)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return removeSession(label)
+}
+
+// processAlive reports whether a process with the pid exists
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Signal 0 checks for the process without sending anything; EPERM means it exists but belongs to someone else
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoadRemoveSession(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", os.Getenv("HOME"))
+
+	s := savedSession{Label: "db", PID: os.Getpid(), Target: "http://db.ns.svc:5432", Pod: "ns/db-0", LocalPort: 15432, URL: "http://localhost:15432"}
+	if err := saveSession(s); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loaded, err := loadSession("db")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if loaded.PID != s.PID || loaded.LocalPort != s.LocalPort || loaded.Pod != s.Pod {
+		t.Errorf("Expected %+v, got %+v", s, *loaded)
+	}
+
+	// The test process is alive, so another session cannot take the label
+	if err := saveSession(s); err == nil {
+		t.Errorf("Expected error saving over a running session, got nil")
+	}
+
+	if err := removeSession("db"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := loadSession("db"); !os.IsNotExist(err) {
+		t.Errorf("Expected the session file to be gone, got %v", err)
+	}
+	if err := closeSession("db"); err == nil {
+		t.Errorf("Expected error closing a missing session, got nil")
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", os.Getenv("HOME"))
+
+	if sessions, err := listSessions(); err != nil || len(sessions) != 0 {
+		t.Fatalf("Expected no sessions before any are saved, got %v, %v", sessions, err)
+	}
+
+	now := time.Now()
+	live := []savedSession{
+		{Label: "newer", PID: os.Getpid(), StartedAt: now.Add(-time.Minute)},
+		{Label: "older", PID: os.Getpid(), StartedAt: now.Add(-time.Hour)},
+	}
+	// No system hands out pids this large, so the session's process is gone
+	stale := savedSession{Label: "stale", PID: 1 << 30, StartedAt: now}
+	for _, s := range append(live, stale) {
+		if err := saveSession(s); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	sessions, err := listSessions()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].Label != "older" || sessions[1].Label != "newer" {
+		t.Errorf("Expected the live sessions oldest first, got %+v", sessions)
+	}
+	if _, err := loadSession("stale"); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale session file to be removed, got %v", err)
+	}
+}
+
+func TestPrintSessions(t *testing.T) {
+	now := time.Now()
+	sessions := []savedSession{
+		{Label: "db", Target: "http://postgres.data.svc:5432", LocalPort: 15432, StartedAt: now.Add(-90 * time.Minute)},
+		{Label: "api", Target: "http://api.default.svc", LocalPort: 8080, StartedAt: now.Add(-5 * time.Second)},
+	}
+
+	var out strings.Builder
+	if err := printSessions(&out, sessions, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "LABEL  TARGET                         LOCAL PORT  DURATION\n" +
+		"db     http://postgres.data.svc:5432  15432       1h30m0s\n" +
+		"api    http://api.default.svc         8080        5s\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestValidateSessionLabel(t *testing.T) {
+	for _, label := range []string{"db", "api-v2", "team.cache_1"} {
+		if err := validateSessionLabel(label); err != nil {
+			t.Errorf("Unexpected error for %q: %v", label, err)
+		}
+	}
+	for _, label := range []string{"", ".hidden", "a/b", "../db", "two words"} {
+		if err := validateSessionLabel(label); err == nil {
+			t.Errorf("Expected error for %q, got nil", label)
+		}
+	}
+}
+
+func TestCloseSession(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", os.Getenv("HOME"))
+
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start sleep: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	if err := saveSession(savedSession{Label: "sleep", PID: cmd.Process.Pid}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := closeSession("sleep"); err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("Expected the session's process to stop")
+	}
+	if _, err := loadSession("sleep"); !os.IsNotExist(err) {
+		t.Errorf("Expected the session file to be removed, got %v", err)
+	}
+}
+
+func TestCloseSessionStale(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", os.Getenv("HOME"))
+
+	if err := saveSession(savedSession{Label: "stale", PID: 1 << 30}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := closeSession("stale"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := loadSession("stale"); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale session file to be removed, got %v", err)
+	}
+}
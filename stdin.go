@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// stdinResult is the outcome of the request to one of the URLs read by --stdin
+type stdinResult struct {
+	url      string
+	status   int
+	duration time.Duration
+	err      error
+}
+
+// stdinForwarder keeps the port-forward the --stdin requests go through, replacing it only when a URL goes to
+// another pod
+type stdinForwarder struct {
+	ctx  context.Context
+	opts *kurlOptions
+
+	// reserved holds localPort until the first port-forward binds it
+	localPort int
+	reserved  net.Listener
+
+	// res is the resource of the last URL and pod the pod it was resolved to; session forwards to pod
+	res     *forwardTarget
+	pod     *ForwardTarget
+	session *PortForwardSession
+
+	// resolve finds the pod behind a resource and portForward forwards localPort to a pod, replaced in tests
+	resolve     func(res *ForwardTarget) (*ForwardTarget, error)
+	portForward func(pod *ForwardTarget, stopCh <-chan struct{}, readyCh chan struct{}) error
+}
+
+// newStdinForwarder returns a forwarder that resolves resources and forwards to pods through the cluster
+func newStdinForwarder(ctx context.Context, localPort int, reserved net.Listener, opts *kurlOptions) *stdinForwarder {
+	f := &stdinForwarder{ctx: ctx, opts: opts, localPort: localPort, reserved: reserved}
+	f.resolve = func(res *ForwardTarget) (*ForwardTarget, error) {
+		return resolveTarget(ctx, res, opts.kube, opts.resolve)
+	}
+	f.portForward = func(pod *ForwardTarget, stopCh <-chan struct{}, readyCh chan struct{}) error {
+		return runPortForward(ctx, pod, "", f.localPort, opts.kube, stopCh, readyCh)
+	}
+	return f
+}
+
+// forward makes sure the port-forward goes to the pod behind res, returning that pod
+func (f *stdinForwarder) forward(res *forwardTarget) (*ForwardTarget, error) {
+	// The same resource needs no new lookup
+	if f.session != nil && *f.res == *res {
+		return f.pod, nil
+	}
+
+	pod, err := f.resolve(toForwardTarget(res))
+	if err != nil {
+		return nil, err
+	}
+	f.res = res
+	if f.session != nil && *f.pod == *pod {
+		return pod, nil
+	}
+
+	// Another pod needs another port-forward; wait for the old one to let go of its port first
+	f.stop()
+	if f.reserved == nil {
+		if f.localPort, f.reserved, err = reserveLocalPort(f.opts.localPort); err != nil {
+			return nil, err
+		}
+	}
+	if f.opts.showPod {
+		fmt.Fprintf(os.Stderr, "# pod: %s/%s\n", pod.Namespace, pod.Name)
+	}
+	reserved := f.reserved
+	f.reserved = nil
+	session := NewPortForwardSession(f.localPort, func(stopCh <-chan struct{}, readyCh chan struct{}) error {
+		reserved.Close()
+		return f.portForward(pod, stopCh, readyCh)
+	})
+	if err := session.Start(f.ctx); err != nil {
+		return nil, err
+	}
+	f.pod, f.session = pod, session
+	return pod, nil
+}
+
+// stop ends the current port-forward, if any
+func (f *stdinForwarder) stop() {
+	if f.session != nil {
+		f.session.Stop()
+		f.session.Wait()
+		f.session = nil
+	}
+}
+
+// runStdin sends a request to every URL read from r, one per line, with the built-in client. Blank lines and lines
+// starting with # are skipped. Consecutive URLs that go to the same pod share a port-forward. Once r is exhausted
+// a summary of the requests is printed to stderr, and kurl exits with the code of the first failed request, if any.
+func runStdin(ctx context.Context, r io.Reader, localPort int, reserved net.Listener, curlArgs []string, verbose bool, opts *kurlOptions) {
+	forwarder := newStdinForwarder(ctx, localPort, reserved, opts)
+	results, err := stdinRequests(forwarder, r, curlArgs, verbose)
+	reportTraffic(opts)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading URLs from stdin: %v\n", err)
+	}
+	printStdinSummary(os.Stderr, results)
+
+	exitOnTimeout(ctx)
+	if code := stdinExitCode(results, err); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// stdinRequests sends the request to every URL read from r through the forwarder, stopping its port-forward once r
+// is exhausted. It returns the result of each request and the error reading r, if any.
+func stdinRequests(forwarder *stdinForwarder, r io.Reader, curlArgs []string, verbose bool) ([]stdinResult, error) {
+	ctx := forwarder.ctx
+	limiter := newRateLimiter(forwarder.opts)
+
+	var results []stdinResult
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() && ctx.Err() == nil {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		serviceURL := line
+		if inferred, ok := inferURLScheme(line); ok {
+			serviceURL = inferred
+		}
+
+		// Waiting fails when the --max-time deadline would pass first
+		if limiter != nil && limiter.Wait(ctx) != nil {
+			break
+		}
+
+		start := time.Now()
+		status, err := stdinRequest(forwarder, serviceURL, len(results)+1, curlArgs, verbose)
+		results = append(results, stdinResult{url: serviceURL, status: status, duration: time.Since(start), err: err})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", serviceURL, err)
+		}
+	}
+	forwarder.stop()
+	return results, scanner.Err()
+}
+
+// stdinExitCode returns the code kurl exits with after the --stdin requests: that of the first failed request, else
+// 1 when reading stdin failed, else 0
+func stdinExitCode(results []stdinResult, readErr error) int {
+	for _, result := range results {
+		if result.err != nil {
+			return exitCode(result.err)
+		}
+	}
+	if readErr != nil {
+		return 1
+	}
+	return 0
+}
+
+// stdinRequest sends the request for the index-th --stdin URL, returning the response status when there was one
+func stdinRequest(forwarder *stdinForwarder, serviceURL string, index int, curlArgs []string, verbose bool) (int, error) {
+	res, err := parseTarget(serviceURL, forwarder.opts)
+	if err != nil {
+		return 0, fmt.Errorf("invalid service URL: %v", err)
+	}
+	pod, err := forwarder.forward(res)
+	if err != nil {
+		return 0, fmt.Errorf("port-forward failed: %v", err)
+	}
+
+	localURL := reconstructURL(serviceURL, forwarder.localPort)
+	stats, err := runCustomHTTP(forwarder.ctx, curlArgs, localURL, verbose, pod, serviceURL, index, forwarder.opts)
+	return stats.StatusCode, err
+}
+
+// printStdinSummary writes a table with the status and duration of each --stdin request to w
+func printStdinSummary(w io.Writer, results []stdinResult) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "RESULT\tSTATUS\tTIME\tURL")
+	for _, result := range results {
+		outcome, status := "ok", "-"
+		if result.err != nil {
+			outcome = "failed"
+		}
+		if result.status != 0 {
+			status = fmt.Sprint(result.status)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", outcome, status, result.duration.Round(time.Millisecond), result.url)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintStdinSummary(t *testing.T) {
+	results := []stdinResult{
+		{url: "http://api.default.svc/health", status: 200, duration: 35 * time.Millisecond},
+		{url: "http://api.default.svc/missing", status: 404, duration: 1234567 * time.Nanosecond, err: &HTTPError{StatusCode: 404}},
+		{url: "http://nope.default.svc/", duration: 2 * time.Second, err: errors.New("port-forward failed")},
+	}
+
+	var out strings.Builder
+	if err := printStdinSummary(&out, results); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "RESULT  STATUS  TIME  URL\n" +
+		"ok      200     35ms  http://api.default.svc/health\n" +
+		"failed  404     1ms   http://api.default.svc/missing\n" +
+		"failed  -       2s    http://nope.default.svc/\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+// fakeStdinCluster stands in for the cluster behind a stdinForwarder: each service resolves to a pod, and a
+// port-forward to a pod proxies the local port to that pod's server
+type fakeStdinCluster struct {
+	// pods maps service names to the pod they resolve to, and servers pod names to the address they listen on
+	pods    map[string]string
+	servers map[string]string
+
+	resolved  []string
+	forwarded []string
+}
+
+// forwarder returns a stdinForwarder that goes through the fake cluster
+func (c *fakeStdinCluster) forwarder(opts *kurlOptions) *stdinForwarder {
+	f := &stdinForwarder{ctx: context.Background(), opts: opts}
+	f.resolve = func(res *ForwardTarget) (*ForwardTarget, error) {
+		c.resolved = append(c.resolved, res.Name)
+		pod, ok := c.pods[res.Name]
+		if !ok {
+			return nil, fmt.Errorf("service %s not found", res.Name)
+		}
+		return &ForwardTarget{Name: pod, Namespace: res.Namespace, Kind: resourceTypePod, Port: res.Port}, nil
+	}
+	f.portForward = func(pod *ForwardTarget, stopCh <-chan struct{}, readyCh chan struct{}) error {
+		c.forwarded = append(c.forwarded, pod.Name)
+		l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", f.localPort))
+		if err != nil {
+			return err
+		}
+		close(readyCh)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					upstream, err := net.Dial("tcp", c.servers[pod.Name])
+					if err != nil {
+						return
+					}
+					defer upstream.Close()
+					go io.Copy(upstream, conn)
+					io.Copy(conn, upstream)
+				}()
+			}
+		}()
+		<-stopCh
+		return l.Close()
+	}
+	return f
+}
+
+// newFakeStdinCluster starts a server for every pod that answers with the pod's name, or 404 for /missing
+func newFakeStdinCluster(t *testing.T, pods map[string]string) *fakeStdinCluster {
+	c := &fakeStdinCluster{pods: pods, servers: map[string]string{}}
+	for _, pod := range pods {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/missing" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, pod)
+		}))
+		t.Cleanup(server.Close)
+		c.servers[pod] = server.Listener.Addr().String()
+	}
+	return c
+}
+
+func TestStdinRequestsSharePortForward(t *testing.T) {
+	cluster := newFakeStdinCluster(t, map[string]string{"api": "api-1", "web": "web-1", "web-alias": "web-1"})
+	urls := "http://api.default.svc:8080/a\n" +
+		"http://api.default.svc:8080/b\n" +
+		"# comments and blank lines are skipped\n\n" +
+		"http://web.default.svc:8080/\n" +
+		"http://web-alias.default.svc:8080/\n" +
+		"http://api.default.svc:8080/c\n"
+
+	results, err := stdinRequests(cluster.forwarder(&kurlOptions{}), strings.NewReader(urls), nil, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.err != nil || result.status != 200 {
+			t.Errorf("Expected %s to succeed, got status %d and error %v", result.url, result.status, result.err)
+		}
+	}
+
+	// The same resource is not looked up again; another one is, but keeps the port-forward when it has the same pod
+	if expected := []string{"api", "web", "web-alias", "api"}; !reflect.DeepEqual(cluster.resolved, expected) {
+		t.Errorf("Expected lookups of %v, got %v", expected, cluster.resolved)
+	}
+	if expected := []string{"api-1", "web-1", "api-1"}; !reflect.DeepEqual(cluster.forwarded, expected) {
+		t.Errorf("Expected port-forwards to %v, got %v", expected, cluster.forwarded)
+	}
+}
+
+func TestStdinRequestsExitCode(t *testing.T) {
+	cluster := newFakeStdinCluster(t, map[string]string{"api": "api-1"})
+	urls := "http://api.default.svc:8080/\n" +
+		"http://api.default.svc:8080/missing\n" +
+		"http://nope.default.svc:8080/\n"
+
+	// With --fail the 404 is the first failure, so its exit code wins over the failed lookup after it
+	results, err := stdinRequests(cluster.forwarder(&kurlOptions{}), strings.NewReader(urls), []string{"--fail"}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 3 || results[0].err != nil || results[1].status != 404 || results[2].err == nil {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+	if code := stdinExitCode(results, nil); code != 22 {
+		t.Errorf("Expected exit code 22, got %d", code)
+	}
+
+	// Without --fail only the failed lookup is a failure
+	results, _ = stdinRequests(cluster.forwarder(&kurlOptions{}), strings.NewReader(urls), nil, false)
+	if code := stdinExitCode(results, nil); code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+
+	if code := stdinExitCode(results[:1], nil); code != 0 {
+		t.Errorf("Expected exit code 0 when all requests succeed, got %d", code)
+	}
+	if code := stdinExitCode(results[:1], errors.New("read failed")); code != 1 {
+		t.Errorf("Expected exit code 1 when reading stdin failed, got %d", code)
+	}
+}
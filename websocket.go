@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// websocketOptions holds the curl options that apply to a --websocket connection
+type websocketOptions struct {
+	headers  []string
+	insecure bool
+
+	// stdin is sent to the server, one message per read, and the messages received are written to stdout
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// makeWebSocketConnection upgrades a connection to rawURL to a WebSocket and pipes stdin and stdout through it
+// until the server closes the connection or ctx is done. The end of stdin does not close the connection, so that
+// replies to the last message still arrive.
+func makeWebSocketConnection(ctx context.Context, rawURL string, opts websocketOptions) error {
+	// The port-forward speaks http(s); the WebSocket handshake wants ws(s)
+	origin, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	location := *origin
+	if strings.EqualFold(origin.Scheme, "https") {
+		location.Scheme = "wss"
+	} else {
+		location.Scheme = "ws"
+	}
+
+	config, err := websocket.NewConfig(location.String(), origin.String())
+	if err != nil {
+		return fmt.Errorf("error creating WebSocket config: %v", err)
+	}
+	for _, header := range opts.headers {
+		name, value, found := strings.Cut(header, ":")
+		if found {
+			config.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+	if opts.insecure {
+		config.TlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	conn, err := config.DialContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error opening WebSocket: %v", err)
+	}
+	defer conn.Close()
+
+	// Closing the connection is the only way to interrupt a blocked read
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go io.Copy(conn, opts.stdin)
+
+	if _, err := io.Copy(opts.stdout, conn); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("error reading from WebSocket: %v", err)
+	}
+	return ctx.Err()
+}
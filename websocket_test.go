@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestMakeWebSocketConnection(t *testing.T) {
+	// Echo one message back with the request's header, then close the connection
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var message string
+		if err := websocket.Message.Receive(ws, &message); err != nil {
+			t.Errorf("Failed to receive message: %v", err)
+			return
+		}
+		websocket.Message.Send(ws, ws.Request().Header.Get("X-Test")+": "+message)
+	}))
+	defer server.Close()
+
+	var stdout bytes.Buffer
+	err := makeWebSocketConnection(context.Background(), server.URL, websocketOptions{
+		headers: []string{"X-Test: kurl"},
+		stdin:   strings.NewReader("hello\n"),
+		stdout:  &stdout,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stdout.String() != "kurl: hello\n" {
+		t.Errorf("Expected the echoed message, got %q", stdout.String())
+	}
+}